@@ -6,6 +6,8 @@ package zyxindex
 
 import (
 	"bufio"
+	"context"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -19,115 +21,356 @@ const (
 )
 
 // build indexes as shards
-type ShardsBuilder [1 << shardMusk]*ShardBuilder
+type ShardsBuilder struct {
+	shardBits uint
+	vLen      int
+	builders  []*ShardBuilder
+	o         *Options
+
+	vBuf [maxVLen]byte
+	// mus protect builders written by ShardsWriters.
+	mus []sync.Mutex
+	// writerSize is the buffer size of all shards in a ShardsWriter.
+	writerSize int
+}
 
 // NewShardsBuilder creates a shards builder
 // @param dir [in], which dictionary for building shards
+// @param shardBits [in], builds 1<<shardBits shards, no more than MaxShardBits
+// @param vLen [in], the width of offsets, from 5 to 8 bytes
+// @param o [in], the options of building and opening the shards, can be nil
 // @return builder
 // @return err
-func NewShardsBuilder(dir string) (builder *ShardsBuilder, err error) {
-	builder = new(ShardsBuilder)
-	for i := 0; i < 1<<shardMusk; i++ {
-		tmpFile, e := os.Create(filepath.Join(dir, tmp+strconv.Itoa(i)))
-		if e != nil {
-			err = e
-			return
-		}
+func NewShardsBuilder(dir string, shardBits uint, vLen int, o *Options) (builder *ShardsBuilder, err error) {
+	if shardBits > MaxShardBits {
+		return nil, ErrInvalidShardBits
+	}
+	if vLen < minVLen || vLen > maxVLen {
+		return nil, ErrInvalidOffsetWidth
+	}
+	builder = &ShardsBuilder{
+		shardBits: shardBits,
+		vLen:      vLen,
+		builders:  make([]*ShardBuilder, 1<<shardBits),
+		o:         o,
+		mus:       make([]sync.Mutex, 1<<shardBits),
+	}
+	// a quarter of the memory budget is for the buffers of the shards, a
+	// quarter for the ShardsWriters, and a half for generating hash tables.
+	shardNum := int64(1) << shardBits
+	workers := int64(o.GetBuildWorkers())
+	budget := o.GetBuildMemory()
+	bufSize := clamp(budget/4/shardNum, minBufioSize, bufioSize)
+	builder.writerSize = int(clamp(budget/4/workers, 0, shardsWriterSize))
+	if workers > shardNum {
+		workers = shardNum
+	}
+	generateBudget := budget / 2 / workers
+	memLimit := o.GetShardMemory(bufSize)
+	for i := range builder.builders {
 		// {$dir}/hashTable/{$shardId}
 		hashTableFile, e := os.Create(HashTablePath(dir, i))
 		if e != nil {
-			err = e
-			return
+			builder.Abort()
+			return nil, e
+		}
+		// the tmp file is created when the entries exceed memLimit.
+		builder.builders[i] = newShardBuilder(nil, hashTableFile, vLen, int(bufSize), generateBudget)
+		builder.builders[i].tmpPath = filepath.Join(dir, tmp+strconv.Itoa(i))
+		builder.builders[i].memLimit = int(memLimit)
+		builder.builders[i].hash = o.GetHash()
+	}
+	return
+}
+
+// MaxProbeLength returns the most slots probed to find a key in the hash
+// tables built.
+func (b *ShardsBuilder) MaxProbeLength() (max int) {
+	for _, builder := range b.builders {
+		if builder.maxProbe > max {
+			max = builder.maxProbe
 		}
-		builder[i] = NewBuilder(tmpFile, hashTableFile)
 	}
 	return
 }
 
+// setHash sets the hash function of the keys, recorded in the hash tables.
+func (b *ShardsBuilder) setHash(hash HashFunc) {
+	for _, builder := range b.builders {
+		builder.hash = hash
+	}
+}
+
 func HashTablePath(dir string, i int) string {
 	return filepath.Join(dir, hashTable+strconv.Itoa(i))
 }
 
-var vBuf = make([]byte, vLen)
-
 // Put puts hash64 and offset into shardsbuilder
 // @param hash64, the key in shards
 // @param offset, the value in shards
-// @return err, error
+// @return err, ErrOffsetOverflow if the offset is too large for the offset width.
 func (b *ShardsBuilder) Put(hash64 uint64, offset uint64) (err error) {
-	shardId, key := calcShard(hash64)
+	if offsetOverflow(offset, b.vLen) {
+		return fmt.Errorf("%w: offset %d exceeds %d bytes", ErrOffsetOverflow, offset, b.vLen)
+	}
+	shardId, key := calcShard(hash64, b.shardBits)
+	vBuf := b.vBuf[:b.vLen]
 	littleEndianPutOffset(vBuf, offset)
-	return b[shardId].Put(key, vBuf)
+	return b.builders[shardId].Put(key, vBuf)
+}
+
+// shardsWriterSize is the max buffer size of all shards in a ShardsWriter.
+const shardsWriterSize = 4 << 20
+
+// clamp returns v in [min, max].
+func clamp(v, min, max int64) int64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// ShardsWriter buffers the entries of one goroutine by shard, and writes them
+// into the ShardsBuilder in batches. The ShardsWriters of a ShardsBuilder can
+// be used concurrently, but not with ShardsBuilder.Put.
+type ShardsWriter struct {
+	b       *ShardsBuilder
+	bufs    [][]byte
+	bufSize int
 }
 
-const cpuCores = 8
+// NewShardsWriter creates a ShardsWriter for the builder.
+func (b *ShardsBuilder) NewShardsWriter() *ShardsWriter {
+	slotLen := kLen + b.vLen
+	bufSize := b.writerSize / len(b.builders) / slotLen * slotLen
+	if bufSize < 16*slotLen {
+		bufSize = 16 * slotLen
+	}
+	return &ShardsWriter{
+		b:       b,
+		bufs:    make([][]byte, len(b.builders)),
+		bufSize: bufSize,
+	}
+}
+
+// Put puts hash64 and offset into the buffer of its shard.
+// @return err, ErrOffsetOverflow if the offset is too large for the offset width.
+func (w *ShardsWriter) Put(hash64 uint64, offset uint64) (err error) {
+	vLen := w.b.vLen
+	if offsetOverflow(offset, vLen) {
+		return fmt.Errorf("%w: offset %d exceeds %d bytes", ErrOffsetOverflow, offset, vLen)
+	}
+	shardId := shardIdOf(hash64, w.b.shardBits)
+	buf := w.bufs[shardId]
+	if buf == nil {
+		buf = make([]byte, 0, w.bufSize)
+	}
+	n := len(buf)
+	buf = buf[:n+kLen+vLen]
+	littleEndianPutKey(buf[n:], hash64)
+	littleEndianPutOffset(buf[n+kLen:], offset)
+	w.bufs[shardId] = buf
+	if len(buf)+kLen+vLen > cap(buf) {
+		return w.flush(shardId)
+	}
+	return
+}
+
+func (w *ShardsWriter) flush(shardId int) (err error) {
+	w.b.mus[shardId].Lock()
+	err = w.b.builders[shardId].write(w.bufs[shardId])
+	w.b.mus[shardId].Unlock()
+	w.bufs[shardId] = w.bufs[shardId][:0]
+	return
+}
+
+// Flush writes the buffered entries of all shards into the ShardsBuilder.
+func (w *ShardsWriter) Flush() (err error) {
+	for shardId, buf := range w.bufs {
+		if len(buf) == 0 {
+			continue
+		}
+		err = w.flush(shardId)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
 
 // BuildShards builds shards and Finshes building.
-// BuildShards use cpuCores concurrent
+// BuildShards uses Options.BuildWorkers goroutines. If a shard fails, the
+// shards not started are skipped, and all files of the builder are removed.
 // @return shards
-// @return err
+// @return err, the first error, with the shard id.
 func (b *ShardsBuilder) BuildShards() (shards Shards, err error) {
-	task := make(chan int, 1<<shardMusk)
-	for i := 0; i < 1<<shardMusk; i++ {
+	return b.buildShards(context.Background(), nil)
+}
+
+// buildShards is BuildShards, which stops with ctx.Err() if ctx is done,
+// and counts the finished shards into p.
+func (b *ShardsBuilder) buildShards(ctx context.Context, p *progress) (shards Shards, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	shards = newShards(b.shardBits)
+	task := make(chan int, len(b.builders))
+	for i := range b.builders {
 		task <- i
 	}
 	close(task)
+
+	var mu sync.Mutex
+	fail := func(e error) {
+		mu.Lock()
+		if err == nil {
+			err = e
+		}
+		mu.Unlock()
+		cancel()
+	}
+	workers := b.o.GetBuildWorkers()
+	if workers > len(b.builders) {
+		workers = len(b.builders)
+	}
 	var wg sync.WaitGroup
-	for i := 0; i < cpuCores; i++ {
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for {
-				idx, ok := <-task
-				if !ok {
-					break
-				}
-				err = b[idx].Finish()
-				if err != nil {
+			for idx := range task {
+				if ctx.Err() != nil {
 					return
 				}
-				if file, ok := b[idx].hashTableWriter.(*os.File); ok {
-					hashTable, e := OpenHashTable(file)
-					if e != nil {
-						return
-					}
-					shards[idx] = hashTable
+				table, e := b.buildShard(idx)
+				if e != nil {
+					fail(fmt.Errorf("zyxindex: build shard %d: %w", idx, e))
+					return
 				}
+				shards.tables[idx] = table
+				p.addShard()
 			}
 		}()
 	}
 	wg.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		shards.Close()
+		b.Abort()
+		return Shards{}, err
+	}
 	return
 }
 
+// buildShard finishes the shard idx, and opens its hash table if it is
+// written into a file.
+func (b *ShardsBuilder) buildShard(idx int) (table HashTabler, err error) {
+	err = b.builders[idx].Finish()
+	if err != nil {
+		return
+	}
+	if max := b.o.GetMaxProbeLength(); max > 0 && b.builders[idx].maxProbe > max {
+		return nil, fmt.Errorf("%w: a key is probed %d slots, exceeds %d",
+			ErrProbeTooLong, b.builders[idx].maxProbe, max)
+	}
+	if file, ok := b.builders[idx].hashTableWriter.(*os.File); ok {
+		return openHashTable(file, b.vLen, b.builders[idx].hash, false, b.o)
+	}
+	return
+}
+
+// Abort closes and removes the tmp files and the hash table files of the
+// builder, for a build which fails before BuildShards returns.
+func (b *ShardsBuilder) Abort() {
+	for _, builder := range b.builders {
+		if builder == nil {
+			continue
+		}
+		if builder.tmpFile != nil {
+			builder.tmpFile.Close()
+			os.Remove(builder.tmpFile.Name())
+		}
+		if file, ok := builder.hashTableWriter.(*os.File); ok {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}
+}
+
 // build hashTable, also one shard.
+// The entries are kept in memory until they exceed memLimit, then they are
+// written into the tmp file.
 type ShardBuilder struct {
-	// the template file
+	// the template file, nil if the entries are in memory.
 	tmpFile *os.File
+	// the path of the template file to create.
+	tmpPath string
 
 	// use buffer to write the tmpFile for reducing random writes
 	bufioWriter *bufio.Writer
+	bufSize     int
+	// read the tmpFile when generating the hash table
+	bufioReader *bufio.Reader
+
+	// the entries in memory, and the max bytes of them.
+	mem      []byte
+	memLimit int
 
 	//hashtable writer
 	hashTableWriter io.Writer
 
+	// the value width
+	vLen int
+	// the hash function of the keys
+	hash HashFunc
+	// the most slots probed to find a key, after Finish
+	maxProbe int
+
 	// key count in hashtable
 	keycount int
+
+	// the max bytes of slots in memory when generating, 0 for no limit.
+	budget int64
 }
 
-// 8M * 256 = 2G < 4G
+// 8M * 256 = 2G < 4G, the max buffer size of a shard.
 const bufioSize = 8 << 20
 
+// minBufioSize is the min buffer size of a shard.
+const minBufioSize = 4 << 10
+
+// readerSize is the buffer size of reading the tmpFile.
+const readerSize = 64 << 10
+
 // NewBuilder creates a shard builder
 // @param tmpFile[in], template file
 // @param hashTableWriter, the writer of hash table
+// @param vLen, the value width of hash table
 // @return builder
-func NewBuilder(tmpFile *os.File, hashTableWriter io.Writer) *ShardBuilder {
-	return &ShardBuilder{
+func NewBuilder(tmpFile *os.File, hashTableWriter io.Writer, vLen int) *ShardBuilder {
+	return newShardBuilder(tmpFile, hashTableWriter, vLen, bufioSize, 0)
+}
+
+// newShardBuilder creates a shard builder with a bufSize buffer, which
+// generates the hash table with at most budget bytes of slots in memory.
+// If tmpFile is nil, tmpPath must be set for the entries exceeding memLimit.
+func newShardBuilder(tmpFile *os.File, hashTableWriter io.Writer, vLen int, bufSize int, budget int64) *ShardBuilder {
+	b := &ShardBuilder{
 		tmpFile:         tmpFile,
 		hashTableWriter: hashTableWriter,
-		bufioWriter:     bufio.NewWriterSize(tmpFile, bufioSize),
+		vLen:            vLen,
+		hash:            HashFNV1,
+		bufSize:         bufSize,
+		budget:          budget,
 	}
+	if tmpFile != nil {
+		b.bufioWriter = bufio.NewWriterSize(tmpFile, bufSize)
+	}
+	return b
 }
 
 // Put puts k and v into builder
@@ -135,11 +378,21 @@ func NewBuilder(tmpFile *os.File, hashTableWriter io.Writer) *ShardBuilder {
 // @param v, the value in hash table
 // @return err, error
 func (b *ShardBuilder) Put(k, v []byte) (err error) {
+	if b.tmpFile == nil && len(b.mem)+kLen+b.vLen <= b.memLimit {
+		b.mem = append(b.mem, k[:kLen]...)
+		b.mem = append(b.mem, v[:b.vLen]...)
+		b.keycount++
+		return
+	}
+	err = b.spill()
+	if err != nil {
+		return
+	}
 	_, err = b.bufioWriter.Write(k[:kLen])
 	if err != nil {
 		return
 	}
-	_, err = b.bufioWriter.Write(v[:vLen])
+	_, err = b.bufioWriter.Write(v[:b.vLen])
 	if err != nil {
 		return
 	}
@@ -147,24 +400,79 @@ func (b *ShardBuilder) Put(k, v []byte) (err error) {
 	return
 }
 
+// write writes entries of kLen+vLen bytes into builder.
+func (b *ShardBuilder) write(entries []byte) (err error) {
+	if b.tmpFile == nil && len(b.mem)+len(entries) <= b.memLimit {
+		b.mem = append(b.mem, entries...)
+	} else {
+		err = b.spill()
+		if err != nil {
+			return
+		}
+		_, err = b.bufioWriter.Write(entries)
+		if err != nil {
+			return
+		}
+	}
+	b.keycount += len(entries) / (kLen + b.vLen)
+	return
+}
+
+// spill creates the tmp file and writes the entries in memory into it,
+// if not yet.
+func (b *ShardBuilder) spill() (err error) {
+	if b.tmpFile != nil {
+		return
+	}
+	b.tmpFile, err = os.Create(b.tmpPath)
+	if err != nil {
+		return
+	}
+	b.bufioWriter = bufio.NewWriterSize(b.tmpFile, b.bufSize)
+	_, err = b.bufioWriter.Write(b.mem)
+	b.mem = nil
+	return
+}
+
 // Finish Finshes building and closes the temp file.
 // @return err
 func (b *ShardBuilder) Finish() (err error) {
-	// TODO(tcmichael): do not flush and reuse the buffer.
-	err = b.bufioWriter.Flush()
+	if b.tmpFile == nil {
+		// the entries are in memory.
+		b.maxProbe, err = generate(&memSource{entries: b.mem}, b.keycount, b.vLen, b.hash, b.hashTableWriter, b.budget)
+		b.mem = nil
+	} else {
+		err = b.generateFromFile()
+	}
 	if err != nil {
 		return
 	}
-	_, err = b.tmpFile.Seek(0, 0)
+	if file, ok := b.hashTableWriter.(*os.File); ok {
+		err = file.Sync()
+		if err != nil {
+			return
+		}
+	}
+	if b.tmpFile != nil {
+		b.tmpFile.Close()
+		err = os.Remove(b.tmpFile.Name())
+	}
+	return
+}
+
+func (b *ShardBuilder) generateFromFile() (err error) {
+	// TODO(tcmichael): do not flush and reuse the buffer.
+	err = b.bufioWriter.Flush()
 	if err != nil {
 		return
 	}
-	err = Generate(b, b.keycount, b.hashTableWriter)
+	b.bufioWriter = nil
+	b.bufioReader = bufio.NewReaderSize(b.tmpFile, readerSize)
+	err = b.rewind()
 	if err != nil {
 		return
 	}
-	b.tmpFile.Close()
-	err = os.Remove(b.tmpFile.Name())
+	b.maxProbe, err = generate(b, b.keycount, b.vLen, b.hash, b.hashTableWriter, b.budget)
 	return
 }
 
@@ -173,13 +481,40 @@ func (b *ShardBuilder) Finish() (err error) {
 // @param v[in], the value in hash table
 // @return err, error.
 func (b *ShardBuilder) readNext(k, v []byte) (err error) {
-	_, err = io.ReadFull(b.tmpFile, k)
+	_, err = io.ReadFull(b.bufioReader, k)
 	if err != nil {
 		return err
 	}
-	_, err = io.ReadFull(b.tmpFile, v)
+	_, err = io.ReadFull(b.bufioReader, v)
 	if err != nil {
 		return err
 	}
 	return
 }
+
+// rewind implements kvRewinder, reads the tmpFile again.
+func (b *ShardBuilder) rewind() (err error) {
+	_, err = b.tmpFile.Seek(0, io.SeekStart)
+	b.bufioReader.Reset(b.tmpFile)
+	return
+}
+
+// memSource is a kvReader of the entries in memory.
+type memSource struct {
+	entries []byte
+	off     int
+}
+
+func (s *memSource) readNext(k, v []byte) (err error) {
+	if s.off+len(k)+len(v) > len(s.entries) {
+		return io.ErrUnexpectedEOF
+	}
+	s.off += copy(k, s.entries[s.off:])
+	s.off += copy(v, s.entries[s.off:])
+	return
+}
+
+func (s *memSource) rewind() (err error) {
+	s.off = 0
+	return
+}
@@ -1,12 +1,17 @@
 package zyxindex
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 const (
 	testDir = "test"
+	// testIndexDir is the default index directory of testDir + "/data".
+	testIndexDir = testDir + "/data" + IndexDirSuffix
 )
 
 func init() {
@@ -24,11 +29,11 @@ func TestHashTableBuilder(t *testing.T) {
 	if err != nil {
 		t.Error("create failed:", err)
 	}
-	builder := NewBuilder(tmp, hashTable)
+	builder := NewBuilder(tmp, hashTable, minVLen)
 
 	v := make([]byte, 5)
 	put := func(hash64 uint64, offset uint64) {
-		_, k := calcShard(hash64)
+		_, k := calcShard(hash64, DefaultShardBits)
 		littleEndianPutOffset(v, offset)
 		err := builder.Put(k, v)
 		if err != nil {
@@ -43,12 +48,12 @@ func TestHashTableBuilder(t *testing.T) {
 		t.Error("can't finish", err)
 	}
 	hashTable.Seek(0, 0)
-	table, err := OpenHashTable(hashTable)
+	table, err := OpenHashTable(hashTable, true)
 	if err != nil {
 		t.Error("can't open hash Table:", err)
 	}
 	get := func(hash64 uint64, expected uint64) {
-		_, k := calcShard(hash64)
+		_, k := calcShard(hash64, DefaultShardBits)
 		v, err := table.Get(k)
 		if err != nil {
 			t.Error("read failed:", err)
@@ -66,7 +71,7 @@ func TestHashTableBuilder(t *testing.T) {
 func TestHashTableBuilders(t *testing.T) {
 	defer os.RemoveAll(testDir)
 	os.Mkdir(testDir, 0755)
-	builders, err := NewShardsBuilder(testDir)
+	builders, err := NewShardsBuilder(testDir, DefaultShardBits, minVLen, nil)
 	if err != nil {
 		t.Error("NewHashTableBuilders failed:", err)
 	}
@@ -96,3 +101,210 @@ func TestHashTableBuilders(t *testing.T) {
 	get(1>>56|200, 300)
 	get(2>>56|400, 600)
 }
+
+func TestHashTableBuilders_ShardBits(t *testing.T) {
+	for _, shardBits := range []uint{0, 4, 12} {
+		os.Mkdir(testDir, 0755)
+		builders, err := NewShardsBuilder(testDir, shardBits, minVLen, nil)
+		if err != nil {
+			t.Fatal("NewHashTableBuilders failed:", err)
+		}
+		hashes := []uint64{0, 1<<63 | 5, 1<<60 | 7, 0xffffffffffffffff}
+		for i, hash64 := range hashes {
+			builders.Put(hash64, uint64(i*100))
+		}
+		shards, err := builders.BuildShards()
+		if err != nil {
+			t.Fatal("Create fails:", err)
+		}
+		if len(shards.tables) != 1<<shardBits {
+			t.Errorf("%v should have %v shards", len(shards.tables), 1<<shardBits)
+		}
+		for i, hash64 := range hashes {
+			offset, err := shards.Get(hash64)
+			if err != nil || offset != uint64(i*100) {
+				t.Error("get not same:", shardBits, offset, i*100, err)
+			}
+		}
+		shards.Close()
+		os.RemoveAll(testDir)
+	}
+	if _, err := NewShardsBuilder(testDir, MaxShardBits+1, minVLen, nil); err != ErrInvalidShardBits {
+		t.Error("shard bits should be invalid:", err)
+	}
+}
+
+func TestHashTableBuilders_OffsetWidth(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	builders, err := NewShardsBuilder(testDir, 0, minVLen, nil)
+	if err != nil {
+		t.Fatal("NewHashTableBuilders failed:", err)
+	}
+	if err := builders.Put(0, 1<<40); !errors.Is(err, ErrOffsetOverflow) {
+		t.Error("offset should overflow:", err)
+	}
+	shards, err := builders.BuildShards()
+	if err != nil {
+		t.Fatal("Create fails:", err)
+	}
+	shards.Close()
+
+	builders, err = NewShardsBuilder(testDir, 0, 6, nil)
+	if err != nil {
+		t.Fatal("NewHashTableBuilders failed:", err)
+	}
+	if err := builders.Put(0, 1<<40); err != nil {
+		t.Error("put failed:", err)
+	}
+	shards, err = builders.BuildShards()
+	if err != nil {
+		t.Fatal("Create fails:", err)
+	}
+	defer shards.Close()
+	if offset, err := shards.Get(0); err != nil || offset != 1<<40 {
+		t.Error("get not same:", offset, err)
+	}
+	if _, err := NewShardsBuilder(testDir, 0, maxVLen+1, nil); err != ErrInvalidOffsetWidth {
+		t.Error("offset width should be invalid:", err)
+	}
+}
+
+func TestHashTableBuilders_Error(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	for _, workers := range []int{1, 4} {
+		builders, err := NewShardsBuilder(testDir, 4, minVLen, &Options{BuildWorkers: workers})
+		if err != nil {
+			t.Fatal("NewHashTableBuilders failed:", err)
+		}
+		for i := uint64(0); i < 16; i++ {
+			builders.Put(i<<60, i)
+		}
+		builders.builders[3].hashTableWriter.(*os.File).Close()
+		_, err = builders.BuildShards()
+		if err == nil || !strings.Contains(err.Error(), "shard 3") {
+			t.Errorf("%v should fail with shard 3", err)
+		}
+		if entries, _ := os.ReadDir(testDir); len(entries) != 0 {
+			t.Errorf("%v files should be removed", len(entries))
+		}
+	}
+}
+
+func TestHashTableBuilders_BuildMemory(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	builders, err := NewShardsBuilder(testDir, 1, minVLen, &Options{BuildMemory: 4096, BuildWorkers: 2})
+	if err != nil {
+		t.Fatal("NewHashTableBuilders failed:", err)
+	}
+	if size := builders.builders[0].bufSize; size != minBufioSize {
+		t.Errorf("%v should equal expected(%v)", size, minBufioSize)
+	}
+	for i := uint64(0); i < 1000; i++ {
+		builders.Put(i*0x9e3779b97f4a7c15, i)
+	}
+	shards, err := builders.BuildShards()
+	if err != nil {
+		t.Fatal("build failed:", err)
+	}
+	defer shards.Close()
+	for i := uint64(0); i < 1000; i++ {
+		offset, err := shards.Get(i * 0x9e3779b97f4a7c15)
+		if err != nil || offset != i {
+			t.Errorf("%v should equal expected(%v), %v", offset, i, err)
+		}
+	}
+}
+
+func TestHashTableBuilders_ShardMemory(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	entry := int64(kLen + minVLen)
+	builders, err := NewShardsBuilder(testDir, 1, minVLen, &Options{ShardMemory: 100 * entry})
+	if err != nil {
+		t.Fatal("NewHashTableBuilders failed:", err)
+	}
+	// shard 0 stays in memory, shard 1 spills into its tmp file.
+	for i := uint64(0); i < 50; i++ {
+		builders.Put(i, i)
+	}
+	for i := uint64(0); i < 150; i++ {
+		builders.Put(1<<63|i, 50+i)
+	}
+	if builders.builders[0].tmpFile != nil {
+		t.Errorf("%v should equal expected(%v)", builders.builders[0].tmpFile.Name(), nil)
+	}
+	if builders.builders[1].tmpFile == nil {
+		t.Fatal("shard 1 should spill into the tmp file")
+	}
+	shards, err := builders.BuildShards()
+	if err != nil {
+		t.Fatal("build failed:", err)
+	}
+	defer shards.Close()
+	for i := uint64(0); i < 50; i++ {
+		offset, err := shards.Get(i)
+		if err != nil || offset != i {
+			t.Errorf("%v should equal expected(%v), %v", offset, i, err)
+		}
+	}
+	for i := uint64(0); i < 150; i++ {
+		offset, err := shards.Get(1<<63 | i)
+		if err != nil || offset != 50+i {
+			t.Errorf("%v should equal expected(%v), %v", offset, 50+i, err)
+		}
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(testDir, tmp+"*")); len(tmps) != 0 {
+		t.Errorf("%v should equal expected(%v)", tmps, nil)
+	}
+}
+
+func TestHashTableBuilders_MaxProbeLength(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	for _, max := range []int{4, -1} {
+		builders, err := NewShardsBuilder(testDir, 0, minVLen, &Options{MaxProbeLength: max})
+		if err != nil {
+			t.Fatal("NewHashTableBuilders failed:", err)
+		}
+		// 10 keys of the same home slot, and 10 values of one key.
+		for i := uint64(0); i < 10; i++ {
+			builders.Put(i<<10, i)
+			builders.Put(40, i)
+		}
+		shards, err := builders.BuildShards()
+		if max >= 0 {
+			if !errors.Is(err, ErrProbeTooLong) {
+				t.Errorf("%v should equal expected(%v)", err, ErrProbeTooLong)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal("build failed:", err)
+		}
+		shards.Close()
+		if probe := builders.MaxProbeLength(); probe != 10 {
+			t.Errorf("%v should equal expected(%v)", probe, 10)
+		}
+	}
+
+	// the records of a repeated key do not fail the build by default.
+	builders, err := NewShardsBuilder(testDir, 0, minVLen, nil)
+	if err != nil {
+		t.Fatal("NewHashTableBuilders failed:", err)
+	}
+	for i := uint64(0); i < 2000; i++ {
+		builders.Put(40, i)
+	}
+	builders.Put(41, 2000)
+	shards, err := builders.BuildShards()
+	if err != nil {
+		t.Fatal("build failed:", err)
+	}
+	shards.Close()
+	if probe := builders.MaxProbeLength(); probe <= 1024 {
+		t.Errorf("%v should exceed %v", probe, 1024)
+	}
+}
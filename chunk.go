@@ -0,0 +1,204 @@
+package zyxindex
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+	scan the data file in parallel.
+
+	The data file is split into chunks at record boundaries, by a first pass
+	walking the record heads, or by the boundaries in Options.BoundaryFile.
+	The chunks are sent to the workers as soon as they are found, every
+	worker scans its chunks with buffered reads, and puts the entries into a
+	ShardsWriter of its own, so the shards are written in batches.
+
+	The entries of a key are not put in file order, Generate sorts the values
+	of a key in hash tables.
+*/
+
+// minChunkSize is the min size of a chunk, smaller data files are scanned
+// by one goroutine.
+var minChunkSize int64 = 64 << 20
+
+// chunk is the records in [from, to) of the data file.
+type chunk struct {
+	from, to int64
+	// last is true for the last chunk, whose to may cross a record.
+	last bool
+}
+
+// errScanStopped stops the workers after an error.
+var errScanStopped = errors.New("zyxindex: scan stopped")
+
+// scanChunks scans the records in [from, to) of the data file by
+// Options.BuildWorkers goroutines, and puts them into builder.
+// A record crossing to is not scanned if tail is true, it may be being
+// appended, otherwise it is corrupt.
+// The scan is stopped with ctx.Err() if ctx is done, the progress is counted
+// into p, which can be nil.
+// @return end, the end of the last scanned record.
+func (db *DB) scanChunks(ctx context.Context, from, to int64, tail bool, builder *ShardsBuilder, p *progress) (end int64, err error) {
+	workers := db.o.GetBuildWorkers()
+	if workers <= 1 || to-from < 2*minChunkSize {
+		c := &scanCounter{ctx: ctx, p: p, offset: from}
+		end, err = db.scan(from, to, tail, func(hash64 uint64, offset uint64) error {
+			if err := c.count(int64(offset)); err != nil {
+				return err
+			}
+			return builder.Put(hash64, offset)
+		})
+		c.flush(end)
+		return
+	}
+	chunkSize := (to - from) / int64(workers*4)
+	if chunkSize < minChunkSize {
+		chunkSize = minChunkSize
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		stopped  int32
+		wg       sync.WaitGroup
+	)
+	fail := func(e error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = e
+		}
+		mu.Unlock()
+		atomic.StoreInt32(&stopped, 1)
+	}
+	chunks := make(chan chunk, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := builder.NewShardsWriter()
+			for ch := range chunks {
+				c := &scanCounter{ctx: ctx, p: p, offset: ch.from}
+				e, err := db.scan(ch.from, ch.to, ch.last && tail, func(hash64, offset uint64) error {
+					if atomic.LoadInt32(&stopped) != 0 {
+						return errScanStopped
+					}
+					if err := c.count(int64(offset)); err != nil {
+						return err
+					}
+					return w.Put(hash64, offset)
+				})
+				if err == nil && !ch.last && e != ch.to {
+					err = fmt.Errorf("zyxindex: no record boundary at %d", e)
+				}
+				if err != nil {
+					fail(err)
+					continue
+				}
+				c.flush(e)
+				if ch.last {
+					mu.Lock()
+					end = e
+					mu.Unlock()
+				}
+			}
+			if err := w.Flush(); err != nil {
+				fail(err)
+			}
+		}()
+	}
+
+	send := func(c chunk) bool {
+		if err := ctx.Err(); err != nil {
+			fail(err)
+		}
+		if atomic.LoadInt32(&stopped) != 0 {
+			return false
+		}
+		chunks <- c
+		return true
+	}
+	if path := db.o.GetBoundaryFile(); path != "" {
+		err = splitByBoundaryFile(path, from, to, chunkSize, send)
+	} else {
+		err = db.splitByRecords(from, to, tail, chunkSize, send)
+	}
+	close(chunks)
+	wg.Wait()
+	if err == nil && firstErr != nil {
+		err = firstErr
+	}
+	return
+}
+
+// splitByRecords walks the record heads in [from, to), and sends the chunks
+// of at least chunkSize bytes, until send returns false. tail is as in
+// scanChunks.
+func (db *DB) splitByRecords(from, to int64, tail bool, chunkSize int64, send func(chunk) bool) (err error) {
+	d := newRecordDecoder(db.file, from, to, tail, true, db.o)
+	start := from
+	for {
+		_, _, _, err = d.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return
+		}
+		if d.offset-start >= chunkSize {
+			if !send(chunk{from: start, to: d.offset}) {
+				return nil
+			}
+			start = d.offset
+		}
+	}
+	send(chunk{from: start, to: d.offset, last: true})
+	return nil
+}
+
+// splitByBoundaryFile sends the chunks of at least chunkSize bytes in
+// [from, to) split at the boundaries in the file at path, until send returns
+// false.
+func splitByBoundaryFile(path string, from, to, chunkSize int64, send func(chunk) bool) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	start := from
+	last := int64(-1)
+	b := make([]byte, 8)
+	for {
+		_, err = io.ReadFull(r, b)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("zyxindex: read %s: %w", path, err)
+		}
+		boundary := int64(binary.LittleEndian.Uint64(b))
+		if boundary <= last {
+			return fmt.Errorf("zyxindex: boundaries in %s are not ascending at %d", path, boundary)
+		}
+		last = boundary
+		if boundary >= to {
+			break
+		}
+		if boundary-start >= chunkSize {
+			if !send(chunk{from: start, to: boundary}) {
+				return nil
+			}
+			start = boundary
+		}
+	}
+	send(chunk{from: start, to: to, last: true})
+	return nil
+}
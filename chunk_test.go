@@ -0,0 +1,77 @@
+package zyxindex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDB_ScanChunks(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	defer func(size int64) { minChunkSize = size }(minChunkSize)
+	minChunkSize = 100
+
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	boundaries, err := os.Create(testDir + "/boundaries")
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	offset, middle := int64(0), int64(0)
+	for i := 0; i < 1000; i++ {
+		if i == 500 {
+			middle = offset
+		}
+		k, v := fmt.Sprintf("key%d", i%300), fmt.Sprintf("value%d", i)
+		if i%7 == 0 {
+			binary.Write(boundaries, binary.LittleEndian, uint64(offset))
+		}
+		writeRecord(file, k, v)
+		offset += int64(16 + len(k) + len(v))
+	}
+	// a record being appended
+	binary.Write(file, binary.LittleEndian, uint64(3))
+	file.Close()
+	boundaries.Close()
+
+	for _, o := range []*Options{
+		{BuildWorkers: 4},
+		{BuildWorkers: 3, BoundaryFile: testDir + "/boundaries"},
+	} {
+		o.Rebuild = true
+		o.Logger = &testLogger{}
+		db, err := OpenWithOptions(dataPath, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.manifest.DataSize != offset {
+			t.Errorf("%v should equal expected(%v)", db.manifest.DataSize, offset)
+		}
+		for i := 0; i < 300; i++ {
+			values, err := db.Gets([]byte(fmt.Sprintf("key%d", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for j, v := range values {
+				if expected := fmt.Sprintf("value%d", i+j*300); string(v) != expected {
+					t.Errorf("%v: %q should equal %q", o.BoundaryFile, v, expected)
+				}
+			}
+		}
+		db.Close()
+	}
+
+	// a boundary in a record.
+	boundaries, _ = os.Create(testDir + "/boundaries")
+	binary.Write(boundaries, binary.LittleEndian, uint64(middle+1))
+	boundaries.Close()
+	_, err = OpenWithOptions(dataPath, &Options{BuildWorkers: 2, BoundaryFile: testDir + "/boundaries", Rebuild: true, Logger: &testLogger{}})
+	if err == nil {
+		t.Error("the invalid boundary should fail the build")
+	}
+}
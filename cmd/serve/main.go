@@ -0,0 +1,161 @@
+// Command serve serves a zyxindex data file over HTTP, read only, by the
+// routes of package zyxhttp.
+//
+// Usage:
+//
+//	serve [flags] <data file>
+//
+// The indexes must be built first, by zyxindex build. On SIGINT or SIGTERM
+// serve stops accepting connections, waits for the requests in flight up to
+// -shutdown-timeout, closes the connections left, and closes the DB after
+// their handlers return.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"tcmichael/zyxindex"
+	"tcmichael/zyxindex/zyxhttp"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stderr, nil))
+}
+
+// run serves until ctx is done, ready receives the address listened on.
+func run(ctx context.Context, args []string, stderr io.Writer, ready func(addr string)) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	logger := log.New(stderr, "", log.LstdFlags)
+	o := &zyxindex.Options{ReadOnly: true, Logger: logger}
+	ho := &zyxhttp.Options{}
+	addr := fs.String("addr", ":8080", "the address to listen on")
+	fs.StringVar(&o.IndexDir, "index", "", "the index directory, the data file path with the suffix .idx by default")
+	fs.BoolVar(&o.Mmap, "mmap", false, "memory map the hash tables")
+	stale := fs.Bool("stale", false, "serve stale indexes instead of failing")
+	fs.IntVar(&ho.MaxBatch, "max-batch", zyxhttp.DefaultMaxBatch, "the max keys of a multiget request")
+	fs.Int64Var(&ho.MaxBodySize, "max-body", zyxhttp.DefaultMaxBodySize, "the max bytes of a multiget request body")
+	fs.Int64Var(&ho.MaxResponseBytes, "max-response", zyxhttp.DefaultMaxResponseBytes, "the max bytes of the values of a multiget response")
+	timeout := fs.Duration("shutdown-timeout", 10*time.Second, "how long to wait for the requests in flight on shutdown")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "serve: a data file is required")
+		fs.Usage()
+		return 2
+	}
+	if *stale {
+		o.StalePolicy = zyxindex.StaleIgnore
+	}
+	db, err := zyxindex.OpenWithOptions(fs.Arg(0), o)
+	if err != nil {
+		fmt.Fprintln(stderr, "serve:", err)
+		return 2
+	}
+	defer db.Close()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintln(stderr, "serve:", err)
+		return 2
+	}
+	inflight := newRequests()
+	defer inflight.wait()
+	srv := &http.Server{
+		Handler:           inflight.handler(zyxhttp.NewHandler(db, ho)),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          logger,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+	logger.Printf("serve %s on %s", fs.Arg(0), ln.Addr())
+	if ready != nil {
+		ready(ln.Addr().String())
+	}
+
+	select {
+	case err = <-errc:
+		fmt.Fprintln(stderr, "serve:", err)
+		return 1
+	case <-ctx.Done():
+	}
+	logger.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err = srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintln(stderr, "serve: shutdown:", err)
+		// the handlers may still be reading the DB, close their connections
+		// and wait for them before the DB is closed.
+		srv.Close()
+		<-errc
+		return 1
+	}
+	if err = <-errc; !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(stderr, "serve:", err)
+		return 1
+	}
+	return 0
+}
+
+// requests counts the requests in flight, so that the DB is closed after
+// their handlers return.
+type requests struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	n      int
+	closed bool
+}
+
+func newRequests() *requests {
+	r := &requests{}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// handler counts the requests to h, the requests after wait get 503.
+func (r *requests) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		r.n++
+		r.mu.Unlock()
+		defer func() {
+			r.mu.Lock()
+			r.n--
+			r.cond.Broadcast()
+			r.mu.Unlock()
+		}()
+		h.ServeHTTP(w, req)
+	})
+}
+
+// wait waits for the requests in flight.
+func (r *requests) wait() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for r.n > 0 {
+		r.cond.Wait()
+	}
+}
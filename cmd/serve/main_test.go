@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tcmichael/zyxindex"
+)
+
+const testDir = "test"
+
+func writeRecord(file *os.File, k, v string) {
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(k)))
+	file.Write(size[:])
+	file.WriteString(k)
+	binary.LittleEndian.PutUint64(size[:], uint64(len(v)))
+	file.Write(size[:])
+	file.WriteString(v)
+}
+
+func TestRun(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	for i := 0; i < 100; i++ {
+		writeRecord(file, strconv.Itoa(i), strconv.Itoa(i*i))
+	}
+	file.Close()
+
+	var stderr bytes.Buffer
+	if code := run(context.Background(), []string{dataPath}, &stderr, nil); code != 2 {
+		t.Errorf("no indexes: %v should equal expected(2), %s", code, stderr.String())
+	}
+	if err := zyxindex.Build(context.Background(), dataPath, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan int)
+	go func() {
+		done <- run(ctx, []string{"-addr", "127.0.0.1:0", dataPath}, io.Discard, func(addr string) {
+			defer cancel()
+			resp, err := http.Get("http://" + addr + "/v1/keys/7")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || string(body) != "49" {
+				t.Errorf("%v %q should equal expected(200 \"49\")", resp.StatusCode, body)
+			}
+		})
+	}()
+	if code := <-done; code != 0 {
+		t.Errorf("%v should equal expected(0)", code)
+	}
+}
+
+func TestRun_ShutdownTimeout(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "large", strings.Repeat("v", 16<<20))
+	file.Close()
+	if err := zyxindex.Build(context.Background(), dataPath, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a client which does not read the value keeps its handler in flight
+	// after the shutdown timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan int)
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	go func() {
+		done <- run(ctx, []string{"-addr", "127.0.0.1:0", "-shutdown-timeout", "100ms", dataPath}, io.Discard, func(addr string) {
+			defer cancel()
+			var err error
+			conn, err = net.Dial("tcp", addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Write([]byte("GET /v1/keys/large HTTP/1.1\r\nHost: test\r\n\r\n"))
+			conn.Read(make([]byte, 1))
+		})
+	}()
+	select {
+	case code := <-done:
+		if code != 1 {
+			t.Errorf("%v should equal expected(1)", code)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("serve should return after the shutdown timeout")
+	}
+}
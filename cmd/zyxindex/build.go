@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"tcmichael/zyxindex"
+)
+
+// hashFlag is the flag of the hash function of the indexes built.
+type hashFlag struct {
+	h *zyxindex.HashFunc
+}
+
+func (f hashFlag) String() string {
+	if f.h == nil || *f.h == 0 {
+		return zyxindex.DefaultHash.String()
+	}
+	return f.h.String()
+}
+
+func (f hashFlag) Set(s string) (err error) {
+	*f.h, err = zyxindex.ParseHashFunc(s)
+	return
+}
+
+// seedFlag is the flag of the hash seed, nil if not set.
+type seedFlag struct {
+	seed **uint64
+}
+
+func (f seedFlag) String() string {
+	if f.seed == nil || *f.seed == nil {
+		return ""
+	}
+	return strconv.FormatUint(**f.seed, 10)
+}
+
+func (f seedFlag) Set(s string) error {
+	seed, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return err
+	}
+	*f.seed = &seed
+	return nil
+}
+
+// newBuildFlagSet creates the flags of build and rebuild, with the options
+// of building the indexes.
+func newBuildFlagSet(name string, stderr io.Writer) (fs *flag.FlagSet, o *zyxindex.Options, progress *bool) {
+	fs, o = newFlagSet(name, stderr)
+	fs.IntVar(&o.ShardBits, "shard-bits", 0, "build 1<<shard-bits shards, -1 for a single shard (default 8)")
+	fs.IntVar(&o.OffsetWidth, "offset-width", 0, "the bytes of offsets, from 5 to 8 (default by the size of the data file)")
+	fs.Var(hashFlag{&o.Hash}, "hash", "the hash function: fnv1, fnv1a, xxh64 or siphash")
+	fs.Var(seedFlag{&o.HashSeed}, "seed", "the seed of the hash function, 0 for the standard fnv1 and fnv1a (default random)")
+	fs.IntVar(&o.MaxProbeLength, "max-probe", 0, "fail if a key is probed more slots (default no limit)")
+	fs.IntVar(&o.BuildWorkers, "workers", 0, "the goroutines building the indexes (default the CPU count)")
+	fs.Int64Var(&o.BuildMemory, "memory", 0, "the memory budget in bytes (default 1GB)")
+	fs.StringVar(&o.BoundaryFile, "boundary", "", "the sidecar file of record boundaries")
+	fs.DurationVar(&o.ProgressInterval, "interval", zyxindex.DefaultProgressInterval, "how often the progress is printed")
+	progress = fs.Bool("progress", true, "print the progress to stderr")
+	return
+}
+
+func runBuild(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	return build(ctx, "build", args, stdout, stderr)
+}
+
+func runRebuild(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	return build(ctx, "rebuild", args, stdout, stderr)
+}
+
+// build builds the indexes, build fails if they exist and rebuild replaces
+// them.
+func build(ctx context.Context, name string, args []string, stdout, stderr io.Writer) int {
+	fs, o, progress := newBuildFlagSet(name, stderr)
+	paths, ok := parseArgs(fs, args, "a data file")
+	if !ok {
+		return exitError
+	}
+	o.ErrorIfExist = name == "build"
+	if *progress {
+		o.Progress = printProgress(stderr)
+	}
+	err := zyxindex.Build(ctx, paths[0], o)
+	if errors.Is(err, os.ErrExist) {
+		fmt.Fprintf(stderr, "zyxindex %s: the indexes exist, use rebuild to replace them\n", name)
+		return exitError
+	}
+	if err != nil {
+		return exitCode(ctx, name, err, stderr)
+	}
+	return exitOK
+}
+
+// printProgress returns an Options.Progress printing the progress to w.
+func printProgress(w io.Writer) func(zyxindex.BuildProgress) {
+	return func(p zyxindex.BuildProgress) {
+		switch p.Phase {
+		case zyxindex.BuildScanning:
+			fmt.Fprintf(w, "%v: %.1f%%, %d records, %v elapsed%s\n", p.Phase,
+				percent(p.BytesScanned, p.TotalBytes), p.Records, p.Elapsed.Round(time.Second), remaining(p))
+		case zyxindex.BuildGenerating:
+			fmt.Fprintf(w, "%v: %d/%d shards, %v elapsed%s\n", p.Phase,
+				p.ShardsFinished, p.Shards, p.Elapsed.Round(time.Second), remaining(p))
+		default:
+			fmt.Fprintf(w, "%v: %d records in %d shards, %v elapsed\n", p.Phase,
+				p.Records, p.Shards, p.Elapsed.Round(time.Second))
+		}
+	}
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+func remaining(p zyxindex.BuildProgress) string {
+	if p.Remaining == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %v remaining", p.Remaining.Round(time.Second))
+}
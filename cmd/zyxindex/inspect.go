@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"tcmichael/zyxindex"
+)
+
+func runStats(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs, o := newFlagSet("stats", stderr)
+	asJSON := fs.Bool("json", false, "print the statistics as json")
+	paths, ok := parseArgs(fs, args, "a data file")
+	if !ok {
+		return exitError
+	}
+	o.ReadOnly = true
+	o.StalePolicy = zyxindex.StaleIgnore
+	db, err := zyxindex.OpenWithOptions(paths[0], o)
+	if err != nil {
+		return exitCode(ctx, "stats", err, stderr)
+	}
+	defer db.Close()
+
+	s := db.Stats()
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(s); err != nil {
+			return exitCode(ctx, "stats", err, stderr)
+		}
+		return exitOK
+	}
+	load := 0.0
+	if s.Slots > 0 {
+		load = float64(s.Entries) / float64(s.Slots)
+	}
+	fmt.Fprintf(stdout, "generation:        %d\n", s.Generation)
+	fmt.Fprintf(stdout, "shards:            %d\n", s.Shards)
+	fmt.Fprintf(stdout, "offset width:      %d\n", s.OffsetWidth)
+	fmt.Fprintf(stdout, "hash:              %v\n", s.Hash)
+	fmt.Fprintf(stdout, "data size:         %d\n", s.DataSize)
+	fmt.Fprintf(stdout, "base size:         %d\n", s.BaseSize)
+	fmt.Fprintf(stdout, "entries:           %d\n", s.Entries)
+	fmt.Fprintf(stdout, "delta entries:     %d\n", s.DeltaEntries)
+	fmt.Fprintf(stdout, "slots:             %d\n", s.Slots)
+	fmt.Fprintf(stdout, "load factor:       %.3f\n", load)
+	fmt.Fprintf(stdout, "shard entries:     %d - %d\n", s.MinShardEntries, s.MaxShardEntries)
+	fmt.Fprintf(stdout, "max probe length:  %d\n", s.MaxProbeLength)
+	return exitOK
+}
+
+func runDumpShard(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs, _ := newFlagSet("dump-shard", stderr)
+	verify := fs.Bool("verify", false, "verify the checksum of the hash table first")
+	paths, ok := parseArgs(fs, args, "a hash table file")
+	if !ok {
+		return exitError
+	}
+	file, err := os.Open(paths[0])
+	if err != nil {
+		return exitCode(ctx, "dump-shard", err, stderr)
+	}
+	table, err := zyxindex.OpenHashTable(file, *verify)
+	if err != nil {
+		file.Close()
+		fmt.Fprintln(stderr, "zyxindex dump-shard:", err)
+		var corruption *zyxindex.CorruptionError
+		if errors.As(err, &corruption) {
+			return exitProblems
+		}
+		return exitError
+	}
+	defer table.Close()
+
+	w := bufio.NewWriter(stdout)
+	fmt.Fprintf(w, "# %d slots, %d entries, offset width %d, hash %v\n",
+		table.SlotCount(), table.EntryCount(), table.ValueLen(), table.HashFunc())
+	fmt.Fprintln(w, "# slot\tkey\toffset")
+	n := 0
+	err = table.RangeSlots(func(slot uint64, k, v []byte) error {
+		n++
+		if n%1024 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		_, err := fmt.Fprintf(w, "%d\t%014x\t%d\n", slot, littleEndian(k), littleEndian(v))
+		return err
+	})
+	if e := w.Flush(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return exitCode(ctx, "dump-shard", err, stderr)
+	}
+	return exitOK
+}
+
+// littleEndian decodes a little endian integer of up to 8 bytes.
+func littleEndian(b []byte) (n uint64) {
+	for i := len(b) - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	return
+}
@@ -0,0 +1,149 @@
+// Command zyxindex builds, queries and inspects the indexes of zyxindex data
+// files.
+//
+// Usage:
+//
+//	zyxindex build [flags] <data file>
+//	zyxindex rebuild [flags] <data file>
+//	zyxindex get [flags] <data file> <key>
+//	zyxindex scan [flags] <data file>
+//	zyxindex stats [flags] <data file>
+//	zyxindex verify [flags] <data file>
+//	zyxindex dump-shard [flags] <hash table file>
+//
+// Exit codes: 0 on success, 1 if the key is not found or problems are found,
+// 2 on errors, 130 if interrupted.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"tcmichael/zyxindex"
+)
+
+const (
+	exitOK       = 0
+	exitProblems = 1
+	exitError    = 2
+	// exitInterrupted is the status of a command interrupted by SIGINT.
+	exitInterrupted = 130
+)
+
+// command runs a subcommand with its arguments.
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string, stdout, stderr io.Writer) int
+}
+
+var commands = map[string]command{
+	"build":      {"build the indexes, fails if they exist", runBuild},
+	"rebuild":    {"build the indexes, replacing the existing ones", runRebuild},
+	"get":        {"print the value of a key", runGet},
+	"scan":       {"print the records of the data file", runScan},
+	"stats":      {"print the statistics of the indexes", runStats},
+	"verify":     {"verify the indexes against the data file", runVerify},
+	"dump-shard": {"print the slots of a hash table file", runDumpShard},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitError
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "zyxindex: unknown command %q\n", args[0])
+		usage(stderr)
+		return exitError
+	}
+	return cmd.run(ctx, args[1:], stdout, stderr)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: zyxindex <command> [flags] <data file>")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-12s %s\n", name, commands[name].usage)
+	}
+}
+
+// newFlagSet creates the flags of a subcommand, with the options of opening
+// the indexes.
+func newFlagSet(name string, stderr io.Writer) (fs *flag.FlagSet, o *zyxindex.Options) {
+	fs = flag.NewFlagSet("zyxindex "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	o = &zyxindex.Options{Logger: log.New(stderr, "", log.LstdFlags)}
+	fs.StringVar(&o.IndexDir, "index", "", "the index directory, the data file path with the suffix .idx by default")
+	fs.BoolVar(&o.Mmap, "mmap", false, "memory map the hash tables")
+	return
+}
+
+// parseArgs parses the flags and the positional arguments named by names.
+func parseArgs(fs *flag.FlagSet, args []string, names ...string) (values []string, ok bool) {
+	if fs.Parse(args) != nil {
+		return nil, false
+	}
+	if fs.NArg() != len(names) {
+		fmt.Fprintf(fs.Output(), "%s: %s required\n", fs.Name(), strings.Join(names, " and "))
+		fs.Usage()
+		return nil, false
+	}
+	return fs.Args(), true
+}
+
+// exitCode returns the status of a command failed with err, and prints err.
+func exitCode(ctx context.Context, name string, err error, stderr io.Writer) int {
+	fmt.Fprintf(stderr, "zyxindex %s: %v\n", name, err)
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return exitInterrupted
+	}
+	return exitError
+}
+
+func runVerify(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs, o := newFlagSet("verify", stderr)
+	vo := &zyxindex.VerifyOptions{}
+	fs.BoolVar(&vo.SkipChecksums, "skip-checksums", false, "do not verify the checksums of the hash tables")
+	fs.IntVar(&vo.MaxProblems, "max-problems", zyxindex.DefaultMaxProblems, "the max problems printed")
+	paths, ok := parseArgs(fs, args, "a data file")
+	if !ok {
+		return exitError
+	}
+	o.ReadOnly = true
+	db, err := zyxindex.OpenWithOptions(paths[0], o)
+	if err != nil {
+		return exitCode(ctx, "verify", err, stderr)
+	}
+	defer db.Close()
+	report, err := db.Verify(ctx, vo)
+	if err != nil {
+		return exitCode(ctx, "verify", err, stderr)
+	}
+	for _, p := range report.Problems {
+		fmt.Fprintln(stdout, p)
+	}
+	fmt.Fprintln(stdout, report)
+	if !report.OK() {
+		return exitProblems
+	}
+	return exitOK
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tcmichael/zyxindex"
+)
+
+const testDir = "test"
+
+func writeRecord(file *os.File, k, v string) {
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(k)))
+	file.Write(size[:])
+	file.WriteString(k)
+	binary.LittleEndian.PutUint64(size[:], uint64(len(v)))
+	file.Write(size[:])
+	file.WriteString(v)
+}
+
+// runCommand runs the command of args, and returns its status and output.
+func runCommand(args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(context.Background(), args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestRun(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	for i := 0; i < 100; i++ {
+		writeRecord(file, strconv.Itoa(i), strconv.Itoa(i*i))
+	}
+	writeRecord(file, "7", "seven")
+	file.Close()
+
+	if code, _, _ := runCommand(); code != exitError {
+		t.Errorf("%v should equal expected(%v)", code, exitError)
+	}
+	if code, _, _ := runCommand("unknown", dataPath); code != exitError {
+		t.Errorf("%v should equal expected(%v)", code, exitError)
+	}
+	if code, _, _ := runCommand("get", dataPath, "7"); code != exitError {
+		t.Errorf("get without indexes: %v should equal expected(%v)", code, exitError)
+	}
+	if code, stdout, stderr := runCommand("scan", "-limit", "2", dataPath); code != exitOK ||
+		stdout != "0\t\"0\"\t\"0\"\n18\t\"1\"\t\"1\"\n" {
+		t.Errorf("scan without indexes: %v %q, %s", code, stdout, stderr)
+	}
+	if _, err := os.Stat(dataPath + zyxindex.IndexDirSuffix); !os.IsNotExist(err) {
+		t.Errorf("scan should not build the indexes, %v", err)
+	}
+	if code, _, stderr := runCommand("build", "-shard-bits", "2", "-hash", "siphash", dataPath); code != exitOK ||
+		!strings.Contains(stderr, "done: 101 records") {
+		t.Fatalf("build: %v should equal expected(%v), %s", code, exitOK, stderr)
+	}
+	if code, _, stderr := runCommand("build", dataPath); code != exitError || !strings.Contains(stderr, "use rebuild") {
+		t.Errorf("build again: %v should equal expected(%v), %s", code, exitError, stderr)
+	}
+	if code, _, stderr := runCommand("build", "-hash", "md5", dataPath); code != exitError {
+		t.Errorf("unknown hash: %v should equal expected(%v), %s", code, exitError, stderr)
+	}
+
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+	}{
+		{[]string{"get", dataPath, "7"}, exitOK, "49"},
+		{[]string{"get", "-format", "hex", dataPath, "7"}, exitOK, "3439\n"},
+		{[]string{"get", "-all", "-format", "quote", dataPath, "7"}, exitOK, "\"49\"\n\"seven\"\n"},
+		{[]string{"get", "-key-hex", dataPath, "3939"}, exitOK, "9801"},
+		{[]string{"get", dataPath, "100"}, exitProblems, ""},
+		{[]string{"get", "-all", dataPath, "100"}, exitProblems, ""},
+		{[]string{"get", "-key-hex", dataPath, "zz"}, exitError, ""},
+		{[]string{"get", dataPath}, exitError, ""},
+		{[]string{"scan", "-limit", "2", dataPath}, exitOK, "0\t\"0\"\t\"0\"\n18\t\"1\"\t\"1\"\n"},
+		{[]string{"scan", "-limit", "1", "-values=false", "-format", "hex", dataPath}, exitOK, "0\t30\n"},
+		{[]string{"verify", dataPath}, exitOK,
+			"101 records, 101 entries: 0 checksum mismatches, 0 missing, 0 dangling, 0 misplaced\n"},
+	}
+	for _, tt := range tests {
+		code, stdout, stderr := runCommand(tt.args...)
+		if code != tt.code || (code != exitError && stdout != tt.stdout) {
+			t.Errorf("%v: %v %q should equal expected(%v %q), %s", tt.args, code, stdout, tt.code, tt.stdout, stderr)
+		}
+	}
+
+	code, stdout, _ := runCommand("stats", "-json", dataPath)
+	var stats zyxindex.Stats
+	if err := json.Unmarshal([]byte(stdout), &stats); err != nil || code != exitOK {
+		t.Fatalf("stats: %v %s, %v", code, stdout, err)
+	}
+	if stats.Shards != 4 || stats.Entries != 101 || stats.Hash != zyxindex.HashSipHash {
+		t.Errorf("%+v should have 4 shards and 101 entries", stats)
+	}
+	if code, stdout, _ := runCommand("stats", dataPath); code != exitOK || !strings.Contains(stdout, "hash:              siphash") {
+		t.Errorf("stats: %v %s", code, stdout)
+	}
+
+	tablePath := zyxindex.HashTablePath(zyxindex.GenerationPath(dataPath+zyxindex.IndexDirSuffix, stats.Generation), 0)
+	code, stdout, stderr := runCommand("dump-shard", "-verify", tablePath)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if code != exitOK || len(lines) < 3 || !strings.Contains(lines[0], strconv.Itoa(len(lines)-2)+" entries") {
+		t.Fatalf("dump-shard: %v %s %s", code, stdout, stderr)
+	}
+	fields := strings.Split(lines[2], "\t")
+	if len(fields) != 3 || len(fields[1]) != 14 {
+		t.Errorf("%q should be slot, key and offset", lines[2])
+	}
+
+	// damage the last slot of the hash table.
+	table, err := os.OpenFile(tablePath, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := table.Stat()
+	table.WriteAt([]byte{0xff}, info.Size()-9)
+	table.Close()
+	if code, _, _ := runCommand("dump-shard", "-verify", tablePath); code != exitProblems {
+		t.Errorf("dump-shard: %v should equal expected(%v)", code, exitProblems)
+	}
+	if code, _, _ := runCommand("verify", dataPath); code != exitProblems {
+		t.Errorf("verify: %v should equal expected(%v)", code, exitProblems)
+	}
+	if code, _, _ := runCommand("dump-shard", filepath.Join(testDir, "missing")); code != exitError {
+		t.Errorf("dump-shard: %v should equal expected(%v)", code, exitError)
+	}
+
+	if code, _, stderr := runCommand("rebuild", "-progress=false", dataPath); code != exitOK || strings.Contains(stderr, "done:") {
+		t.Fatalf("rebuild: %v should equal expected(%v), %s", code, exitOK, stderr)
+	}
+	if code, _, _ := runCommand("verify", dataPath); code != exitOK {
+		t.Errorf("verify: %v should equal expected(%v)", code, exitOK)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if code := run(ctx, []string{"rebuild", dataPath}, &bytes.Buffer{}, &bytes.Buffer{}); code != exitInterrupted {
+		t.Errorf("interrupted: %v should equal expected(%v)", code, exitInterrupted)
+	}
+}
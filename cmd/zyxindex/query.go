@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"tcmichael/zyxindex"
+)
+
+// format is how the keys and the values are printed.
+type format string
+
+const (
+	formatRaw   format = "raw"
+	formatHex   format = "hex"
+	formatQuote format = "quote"
+)
+
+func (f *format) String() string {
+	return string(*f)
+}
+
+func (f *format) Set(s string) error {
+	switch v := format(s); v {
+	case formatRaw, formatHex, formatQuote:
+		*f = v
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, use raw, hex or quote", s)
+}
+
+// encode returns b in the format.
+func (f format) encode(b []byte) []byte {
+	switch f {
+	case formatHex:
+		return []byte(hex.EncodeToString(b))
+	case formatQuote:
+		return []byte(strconv.Quote(string(b)))
+	}
+	return b
+}
+
+// copy writes the value read from r in the format, the raw values are
+// streamed as they are, the others end with a newline.
+func (f format) copy(w io.Writer, r io.Reader) (err error) {
+	switch f {
+	case formatRaw:
+		_, err = io.Copy(w, r)
+		return
+	case formatHex:
+		_, err = io.Copy(hex.NewEncoder(w), r)
+	default:
+		var b []byte
+		b, err = io.ReadAll(r)
+		if err != nil {
+			return
+		}
+		_, err = w.Write(f.encode(b))
+	}
+	if err != nil {
+		return
+	}
+	_, err = io.WriteString(w, "\n")
+	return
+}
+
+func runGet(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs, o := newFlagSet("get", stderr)
+	f := formatRaw
+	fs.Var(&f, "format", "print the value as raw, hex or quote")
+	all := fs.Bool("all", false, "print the values of all records of the key, one per line")
+	keyHex := fs.Bool("key-hex", false, "the key is hex encoded")
+	values, ok := parseArgs(fs, args, "a data file", "a key")
+	if !ok {
+		return exitError
+	}
+	key := []byte(values[1])
+	if *keyHex {
+		var err error
+		key, err = hex.DecodeString(values[1])
+		if err != nil {
+			fmt.Fprintln(stderr, "zyxindex get: invalid key:", err)
+			return exitError
+		}
+	}
+	o.ReadOnly = true
+	db, err := zyxindex.OpenWithOptions(values[0], o)
+	if err != nil {
+		return exitCode(ctx, "get", err, stderr)
+	}
+	defer db.Close()
+
+	if *all {
+		vs, err := db.Gets(key)
+		if err == os.ErrNotExist {
+			fmt.Fprintf(stderr, "zyxindex get: key %q not found\n", key)
+			return exitProblems
+		}
+		if err != nil {
+			return exitCode(ctx, "get", err, stderr)
+		}
+		w := bufio.NewWriter(stdout)
+		for _, v := range vs {
+			w.Write(f.encode(v))
+			w.WriteString("\n")
+		}
+		if err = w.Flush(); err != nil {
+			return exitCode(ctx, "get", err, stderr)
+		}
+		return exitOK
+	}
+	vr, err := db.OpenValue(key)
+	if err == os.ErrNotExist {
+		fmt.Fprintf(stderr, "zyxindex get: key %q not found\n", key)
+		return exitProblems
+	}
+	if err != nil {
+		return exitCode(ctx, "get", err, stderr)
+	}
+	if err = f.copy(stdout, vr); err != nil {
+		return exitCode(ctx, "get", err, stderr)
+	}
+	return exitOK
+}
+
+func runScan(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	// the records are read from the data file, the indexes are not needed.
+	fs := flag.NewFlagSet("zyxindex scan", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	f := formatQuote
+	fs.Var(&f, "format", "print the keys and the values as raw, hex or quote")
+	values := fs.Bool("values", true, "print the values")
+	limit := fs.Int64("limit", 0, "print at most limit records, 0 for all")
+	paths, ok := parseArgs(fs, args, "a data file")
+	if !ok {
+		return exitError
+	}
+	file, err := os.Open(paths[0])
+	if err != nil {
+		return exitCode(ctx, "scan", err, stderr)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return exitCode(ctx, "scan", err, stderr)
+	}
+
+	w := bufio.NewWriter(stdout)
+	it := zyxindex.NewFileIterator(file, info.Size(), &zyxindex.IteratorOptions{SkipValues: !*values}, nil)
+	for n := int64(0); (*limit <= 0 || n < *limit) && it.Next(); n++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		fmt.Fprintf(w, "%d\t%s", it.Offset(), f.encode(it.Key()))
+		if *values {
+			fmt.Fprintf(w, "\t%s", f.encode(it.Value()))
+		}
+		w.WriteString("\n")
+	}
+	if err == nil {
+		err = it.Err()
+	}
+	if e := w.Flush(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return exitCode(ctx, "scan", err, stderr)
+	}
+	return exitOK
+}
@@ -5,112 +5,341 @@
 * 	return err;
 * }
 * value, err = DB.get(key)
-* TODO(zhaoyanxing): values, err = DB.gets(key)
+* values, err = DB.gets(key)
+* dst, err = DB.getInto(key, dst)
+* values, errs = DB.multiGet(keys)
+* vr, err = DB.openValue(key)
+* it = DB.newIterator(nil)
  */
 
 package zyxindex
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"log"
 	"os"
-	"path/filepath"
+	"sync"
 )
 
 // DB is the database
 type DB struct {
-	path   string
-	shards Shards
+	path string
+
+	// mu protects shards and manifest, which are replaced by Refresh and Merge.
+	mu       sync.RWMutex
+	shards   Shards
+	manifest *Manifest
+	// hasher hashes the keys by the hash function of manifest.
+	hasher Hasher
+	// updateMu serializes Refresh and Merge.
+	updateMu sync.Mutex
+
+	// where the manifest and hash tables are
+	indexDir string
+	logger   Logger
+	o        *Options
 
 	// data source
 	// (keysize: uint64, key: bytes, valuesize: uint64, value: bytes)
 	file *os.File
 }
 
-// Open opens or creates a DB for the given storage.
-// The DB will be created if not exist, unless ErrorIfMissing is true.
-// Also, if ErrorIfExist is true and the DB exist Open will returns
-// os.ErrExist error.
-//
-// Open will create indexes of the database.
+// Open opens a DB for the given data file with the default options.
+// See OpenWithOptions.
+func Open(path string) (db *DB, err error) {
+	return OpenWithOptions(path, nil)
+}
+
+// OpenWithOptions opens a DB for the given data file.
+// The indexes will be built if not exist, unless ErrorIfMissing or ReadOnly
+// is true, then the error satisfies os.IsNotExist.
+// Also, if ErrorIfExist is true and the indexes exist OpenWithOptions will
+// returns os.ErrExist error. If Rebuild is true, the existing indexes are
+// dropped and built again. If records were appended to the data file after
+// the indexes were built, they are indexed by Refresh. If the data file
+// changed otherwise, StalePolicy decides to rebuild the indexes, to return
+// ErrStaleIndex or to use them anyway. If the indexes are unusable, the error
+// matches ErrVersionMismatch, ErrShardMissing, ErrCorruptIndex and so on, or
+// they are rebuilt if RebuildIfCorrupt is true.
 //
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
-func Open(path string) (db *DB, err error) {
+func OpenWithOptions(path string, o *Options) (db *DB, err error) {
+	return open(context.Background(), path, o)
+}
+
+// Build builds the indexes of the data file at path, the existing indexes are
+// replaced, or os.ErrExist is returned if o.ErrorIfExist is true.
+// The progress is reported to o.Progress.
+// If ctx is done before the indexes are published, Build stops and returns
+// ctx.Err(), the partial indexes are removed and the existing indexes are
+// kept.
+func Build(ctx context.Context, path string, o *Options) (err error) {
+	if o.GetReadOnly() {
+		return ErrReadOnly
+	}
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	opts.Rebuild = true
+	db, err := open(ctx, path, &opts)
+	if err != nil {
+		return
+	}
+	return db.Close()
+}
+
+func open(ctx context.Context, path string, o *Options) (db *DB, err error) {
+	if o.GetReadOnly() && o.GetRebuild() {
+		return nil, ErrReadOnly
+	}
 	file, err := os.OpenFile(path, os.O_RDONLY, 0644)
 	if err != nil {
 		return
 	}
 	db = &DB{
-		path: path,
-		file: file,
+		path:     path,
+		indexDir: o.GetIndexDir(path),
+		logger:   o.GetLogger(),
+		o:        o,
+		file:     file,
 	}
-	manifest, err := loadManifest(filepath.Dir(path))
-	if err != nil {
-		// if manifest not exist, build indexes
-		if os.IsNotExist(err) {
-			log.Println("start build indexes")
-			err = db.preLoad()
+	defer func() {
+		if err != nil {
+			db.shards.Close()
+			file.Close()
+			db = nil
+			return
+		}
+		if !o.GetReadOnly() {
+			db.removeObsolete()
+		}
+	}()
+
+	manifest, err := loadManifest(db.indexDir)
+	switch {
+	case err == nil:
+		if o.GetErrorIfExist() {
+			err = os.ErrExist
+			return
+		}
+		if o.GetRebuild() {
+			break
+		}
+		appended, reason, e := manifest.checkData(file)
+		if e != nil {
+			err = e
+			return
+		}
+		if appended {
+			if !o.GetReadOnly() {
+				err = db.load(manifest)
+				if err == nil {
+					err = db.Refresh()
+				}
+				if !db.rebuildIfCorrupt(err) {
+					return
+				}
+				break
+			}
+			reason = "data appended"
+		}
+		if reason == "" || o.GetStalePolicy() == StaleIgnore {
+			if reason != "" {
+				db.logger.Printf("use stale indexes in %s: %s", db.indexDir, reason)
+			}
+			err = db.load(manifest)
+			if !db.rebuildIfCorrupt(err) {
+				return
+			}
+			break
+		}
+		if o.GetStalePolicy() == StaleError || o.GetReadOnly() {
+			err = fmt.Errorf("%w: %s", ErrStaleIndex, reason)
+			return
+		}
+		db.logger.Printf("indexes in %s are stale: %s", db.indexDir, reason)
+	case os.IsNotExist(err):
+		if o.GetReadOnly() || o.GetErrorIfMissing() {
+			return
 		}
+	default:
+		if !db.rebuildIfCorrupt(err) {
+			return
+		}
+	}
+
+	err = os.MkdirAll(db.indexDir, 0755)
+	if err != nil {
 		return
 	}
-	db.shards, err = LoadFromManifest(filepath.Dir(path), manifest)
+	db.logger.Printf("start build indexes in %s", db.indexDir)
+	err = db.preLoad(ctx)
 	return
 }
 
 const sizeOfuint64 = 8
 
-func (db *DB) preLoad() (err error) {
-	builder, err := NewShardsBuilder(filepath.Dir(db.path))
+func (db *DB) preLoad(ctx context.Context) (err error) {
+	info, err := db.file.Stat()
 	if err != nil {
 		return
 	}
-
-	key := make([]byte, 1<<10) // 1k
-	var offset uint64
-	file := db.file
-
-	//iterator:  (keysize: uint64, key: bytes, valuesize: uint64, value: bytes)
-	for {
-		var keySize, valueSize uint64
-		err = binary.Read(file, binary.LittleEndian, &keySize)
+	seed, random := db.o.GetHashSeed()
+	if random {
+		seed, err = randomSeed()
 		if err != nil {
-			break
+			return
 		}
-		_, err = io.ReadFull(file, key[:int(keySize)])
+	}
+	hasher, err := NewHasher(db.o.GetHash(), seed)
+	if err != nil {
+		return
+	}
+	shardBits := db.o.GetShardBits()
+	vLen := db.o.GetOffsetWidth(info.Size())
+	if info.Size() > 0 && offsetOverflow(uint64(info.Size()-1), vLen) {
+		return fmt.Errorf("%w: data file size %d exceeds %d bytes offsets",
+			ErrOffsetOverflow, info.Size(), vLen)
+	}
+
+	// build in a new generation, which is used after published.
+	generation, err := newGeneration(db.indexDir)
+	if err != nil {
+		return
+	}
+	dir := GenerationPath(db.indexDir, generation)
+	var shards Shards
+	defer func() {
 		if err != nil {
-			break
+			shards.Close()
+			os.RemoveAll(dir)
 		}
-		err = binary.Read(file, binary.LittleEndian, &valueSize)
-		if err != nil {
-			break
+	}()
+	builder, err := NewShardsBuilder(dir, shardBits, vLen, db.o)
+	if err != nil {
+		return
+	}
+	db.hasher = hasher
+	p := newProgress(db.o.GetProgress(), db.o.GetProgressInterval(), info.Size(), 1<<shardBits)
+	defer func() {
+		p.finish(err)
+	}()
+	end, err := db.scanChunks(ctx, 0, info.Size(), true, builder, p)
+	if err != nil {
+		builder.Abort()
+		return
+	}
+	db.logPartial(end, info.Size())
+	p.setPhase(BuildGenerating)
+	shards, err = builder.buildShards(ctx, p)
+	if err != nil {
+		return
+	}
+	manifest := &Manifest{
+		Version:        version,
+		Generation:     generation,
+		ShardNum:       1 << shardBits,
+		OffsetWidth:    vLen,
+		Hash:           db.o.GetHash(),
+		HashSeed:       seed,
+		MaxProbeLength: builder.MaxProbeLength(),
+		BaseSize:       end,
+		BaseGeneration: generation,
+	}
+	err = manifest.setData(db.file, end, info)
+	if err != nil {
+		return
+	}
+	err = publish(db.indexDir, manifest)
+	if err != nil {
+		return
+	}
+	db.shards, db.manifest = shards, manifest
+	db.logger.Printf("build indexes in %s: %d bytes, max probe length %d",
+		db.indexDir, end, manifest.MaxProbeLength)
+	return
+}
+
+// scan scans the records in [from, to) of the data file, and calls fn with
+// the key hash and the offset of every record. A record crossing to is
+// not scanned if tail is true, it may be being appended, otherwise it is
+// corrupt.
+// @return end, the end of the last scanned record.
+func (db *DB) scan(from, to int64, tail bool, fn func(hash64 uint64, offset uint64) error) (end int64, err error) {
+	d := newRecordDecoder(db.file, from, to, tail, true, db.o)
+	end = from
+	for {
+		offset, key, _, e := d.next()
+		if e == io.EOF {
+			return
+		}
+		if e != nil {
+			return end, e
 		}
-		_, err = file.Seek(int64(valueSize), 1)
+		err = fn(db.hasher.Sum64(key), uint64(offset))
 		if err != nil {
-			break
+			return
 		}
-		hash64 := fnvHash64(key[:keySize])
-		builder.Put(hash64, offset)
-		offset += sizeOfuint64 + keySize + sizeOfuint64 + valueSize
+		end = d.offset
 	}
-	if err != io.EOF {
-		return
+}
+
+// logPartial logs the record at end crossing size, which is not indexed
+// until it is appended completely and the indexes are refreshed.
+func (db *DB) logPartial(end, size int64) {
+	if end < size {
+		db.logger.Printf("record at offset %d of %s crosses the end %d, not indexed",
+			end, db.path, size)
+	}
+}
+
+// rebuildIfCorrupt reports whether the indexes are unusable by err and should
+// be rebuilt by Options.RebuildIfCorrupt, the shards loaded are closed then.
+func (db *DB) rebuildIfCorrupt(err error) bool {
+	if err == nil || !db.o.GetRebuildIfCorrupt() || db.o.GetReadOnly() || !isUnusable(err) {
+		return false
+	}
+	db.logger.Printf("rebuild unusable indexes in %s: %v", db.indexDir, err)
+	db.shards.Close()
+	db.shards, db.manifest = Shards{}, nil
+	return true
+}
+
+// isUnusable reports whether err means the indexes can not be used.
+func isUnusable(err error) bool {
+	for _, target := range []error{ErrVersionMismatch, ErrShardMissing, ErrCorruptIndex,
+		ErrInvalidOffsetWidth, ErrUnknownHash, ErrHashMismatch} {
+		if errors.Is(err, target) {
+			return true
+		}
 	}
-	db.shards, err = builder.BuildShards()
+	return false
+}
+
+// removeObsolete removes the generations and tmp files not used by the DB.
+func (db *DB) removeObsolete() {
+	err := removeObsolete(db.indexDir, db.manifest)
 	if err != nil {
-		return
+		db.logger.Printf("remove obsolete indexes in %s: %v", db.indexDir, err)
 	}
-	return buildManifest(filepath.Dir(db.path))
 }
 
-func buildManifest(dir string) error {
-	mainfest := &Manifest{
-		Version:  version,
-		ShardNum: 1 << shardMusk,
+// load loads the shards of manifest.
+func (db *DB) load(manifest *Manifest) (err error) {
+	db.hasher, err = manifest.hasher()
+	if err != nil {
+		return
 	}
-	return CreateManifestFile(dir, mainfest)
+	db.shards, err = LoadFromManifest(db.indexDir, manifest, db.o)
+	if err != nil {
+		return
+	}
+	db.manifest = manifest
+	return
 }
 
 // Close closes the DB.
@@ -118,59 +347,104 @@ func buildManifest(dir string) error {
 // It is valid to call Close multiple times. Other methods should not be
 // called after the DB has been closed.
 func (db *DB) Close() error {
-	for i := 0; i < 1<<shardMusk; i++ {
-		err := db.shards[i].Close()
-		if err != nil {
-			return err
-		}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	err := db.shards.Close()
+	if err != nil {
+		return err
 	}
 	return db.file.Close()
 }
 
 // Get gets the value for the given key. It returns ErrNotFound if the
 // DB does not contains the key.
+// If the key appears more than once in the data file, Get returns the first
+// one in file order.
 //
 // The returned slice is its own copy, it is safe to modify the contents
 // of the returned slice.
 // It is safe to modify the contents of the argument after Get returns.
 //
-// @return err, os.ErrNotExist if the key is not found.
+// @return err, os.ErrNotExist if the key is not found, ErrValueTooLarge if
+// the value is too large to read into memory, see Options.MaxValueSize.
 func (db *DB) Get(key []byte) (value []byte, err error) {
-	hash64 := fnvHash64(key)
-	offset, err := db.shards.Get(hash64)
-	if err != nil {
-		return
+	value, err = db.GetInto(key, nil)
+	if err == nil && value == nil {
+		value = []byte{}
+	}
+	return
+}
+
+// GetInto is like Get, but appends the value to dst and returns the extended
+// slice, dst is returned unchanged on error. Nothing is allocated if dst has
+// enough capacity for the value.
+//
+// @return err, os.ErrNotExist if the key is not found.
+func (db *DB) GetInto(key, dst []byte) (value []byte, err error) {
+	l := lookupPool.Get().(*lookup)
+	defer lookupPool.Put(l)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var valueOffset int64
+	var valueSize uint64
+	err = db.findRecords(key, l, func(offset int64, size uint64) bool {
+		valueOffset, valueSize = offset, size
+		return false
+	})
+	if err == nil {
+		err = db.checkReadSize(valueSize)
 	}
-	uint64Buffer := make([]byte, 8)
-	_, err = db.file.ReadAt(uint64Buffer, int64(offset))
 	if err != nil {
-		return
+		return dst, err
 	}
-	keySize := binary.LittleEndian.Uint64(uint64Buffer)
-	if int(keySize) != len(key) {
-		err = os.ErrNotExist
-		return
+	n := len(dst)
+	if uint64(cap(dst)-n) < valueSize {
+		value = append(make([]byte, 0, n+int(valueSize)), dst...)
+	} else {
+		value = dst
 	}
-	keyBuffer := make([]byte, len(key))
-	_, err = db.file.ReadAt(keyBuffer, int64(offset+8))
+	value = value[:n+int(valueSize)]
+	_, err = db.file.ReadAt(value[n:], valueOffset)
 	if err != nil {
-		return
-	}
-	if !bytes.Equal(keyBuffer, key) {
-		err = os.ErrNotExist
-		return
+		return dst, err
 	}
+	return
+}
 
-	_, err = db.file.ReadAt(uint64Buffer, int64(offset+8+keySize))
-	if err != nil {
-		return
+// Gets gets all values for the given key, in file order.
+// It returns os.ErrNotExist if the DB does not contains the key.
+//
+// The returned slices are their own copies, it is safe to modify the contents
+// of the returned slices.
+func (db *DB) Gets(key []byte) (values [][]byte, err error) {
+	l := lookupPool.Get().(*lookup)
+	defer lookupPool.Put(l)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var readErr error
+	err = db.findRecords(key, l, func(offset int64, size uint64) bool {
+		if readErr = db.checkReadSize(size); readErr != nil {
+			return false
+		}
+		value := make([]byte, int(size))
+		_, readErr = db.file.ReadAt(value, offset)
+		values = append(values, value)
+		return readErr == nil
+	})
+	if err == nil {
+		err = readErr
 	}
-	valueSize := binary.LittleEndian.Uint64(uint64Buffer)
-	valueBuffer := make([]byte, int(valueSize))
-	_, err = db.file.ReadAt(valueBuffer, int64(offset+16+keySize))
 	if err != nil {
-		return
+		return nil, err
 	}
-	value = valueBuffer[:int(valueSize)]
 	return
 }
+
+// checkReadSize returns ErrValueTooLarge if a value of size is too large to
+// read into memory.
+func (db *DB) checkReadSize(size uint64) error {
+	if max := db.o.GetMaxReadSize(); size > max {
+		return fmt.Errorf("%w: %d bytes exceeds %d", ErrValueTooLarge, size, max)
+	}
+	return nil
+}
@@ -3,7 +3,11 @@ package zyxindex
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 )
 
@@ -40,3 +44,672 @@ func TestDB(t *testing.T) {
 		}
 	}
 }
+
+func writeRecord(file *os.File, k, v string) {
+	binary.Write(file, binary.LittleEndian, uint64(len(k)))
+	file.Write([]byte(k))
+	binary.Write(file, binary.LittleEndian, uint64(len(v)))
+	file.Write([]byte(v))
+}
+
+func TestDB_Gets(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "k", "1")
+	writeRecord(file, "other", "x")
+	writeRecord(file, "k", "2")
+	writeRecord(file, "k", "3")
+	file.Close()
+	db, err := Open(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	values, err := db.Gets([]byte("k"))
+	if err != nil {
+		t.Fatal("gets failed", err)
+	}
+	if len(values) != 3 {
+		t.Fatalf("%q should have 3 values", values)
+	}
+	for i, v := range []string{"1", "2", "3"} {
+		if string(values[i]) != v {
+			t.Errorf("%q should equal %q", values[i], v)
+		}
+	}
+	value, err := db.Get([]byte("k"))
+	if err != nil || string(value) != "1" {
+		t.Errorf("%q should equal %q, %v", value, "1", err)
+	}
+	if _, err := db.Gets([]byte("missing")); err != os.ErrNotExist {
+		t.Errorf("missing should not exist, %v", err)
+	}
+}
+
+func TestDB_GetCollision(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	file, err := os.Create(testDir + "/data")
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "a", "1")
+	writeRecord(file, "b", "2")
+	file.Close()
+	file, err = os.Open(testDir + "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "b" pretends to have the same hash as "a" and comes first.
+	builder, err := NewShardsBuilder(testDir, DefaultShardBits, minVLen, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder.Put(fnvHash64([]byte("a")), 8+1+8+1)
+	builder.Put(fnvHash64([]byte("a")), 0)
+	shards, err := builder.BuildShards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &DB{file: file, shards: shards, hasher: fnv1Hasher{basis: fnvOffset64}}
+	defer db.Close()
+	value, err := db.Get([]byte("a"))
+	if err != nil || string(value) != "1" {
+		t.Errorf("%q should equal %q, %v", value, "1", err)
+	}
+	values, err := db.Gets([]byte("a"))
+	if err != nil || len(values) != 1 {
+		t.Errorf("%q should have 1 value, %v", values, err)
+	}
+}
+
+func TestDB_GetInto(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "k", "value")
+	writeRecord(file, "empty", "")
+	writeRecord(file, "k", "other")
+	file.Close()
+	for _, mmap := range []bool{false, true} {
+		db, err := OpenWithOptions(dataPath, &Options{Mmap: mmap})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := db.GetInto([]byte("k"), []byte("prefix:"))
+		if err != nil || string(dst) != "prefix:value" {
+			t.Errorf("%q should equal %q, %v", dst, "prefix:value", err)
+		}
+		dst, err = db.GetInto([]byte("missing"), dst[:7])
+		if err != os.ErrNotExist || string(dst) != "prefix:" {
+			t.Errorf("%q should equal %q, %v", dst, "prefix:", err)
+		}
+		if value, err := db.Get([]byte("empty")); err != nil || value == nil || len(value) != 0 {
+			t.Errorf("%q should be empty, %v", value, err)
+		}
+
+		key := []byte("k")
+		dst = make([]byte, 0, 64)
+		allocs := testing.AllocsPerRun(100, func() {
+			db.GetInto(key, dst[:0])
+		})
+		if allocs != 0 {
+			t.Errorf("mmap %v: %v allocs should be 0", mmap, allocs)
+		}
+		db.Close()
+	}
+}
+
+func TestDB_GetHashTabler(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	file, err := os.Create(testDir + "/data")
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "a", "1")
+	writeRecord(file, "a", "2")
+
+	// a HashTabler without offsetProber is looked up by Gets.
+	shards := newShards(0)
+	hash64 := fnvHash64([]byte("a"))
+	v := make([]byte, minVLen)
+	littleEndianPutOffset(v, 8+1+8+1)
+	shards.tables[0] = &MapHashTable{Map: map[uint64][]byte{hash64 & (1<<56 - 1): v}}
+	db := &DB{file: file, shards: shards, hasher: fnv1Hasher{basis: fnvOffset64}}
+	defer db.Close()
+	value, err := db.Get([]byte("a"))
+	if err != nil || string(value) != "2" {
+		t.Errorf("%q should equal %q, %v", value, "2", err)
+	}
+	if _, err := db.Get([]byte("b")); err != os.ErrNotExist {
+		t.Errorf("b: data shoule not exist, %v", err)
+	}
+}
+
+func BenchmarkDB_Get(b *testing.B) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		b.Fatal("create file failed", err)
+	}
+	const n = 1 << 14
+	for i := 0; i < n; i++ {
+		writeRecord(file, fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	file.Close()
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%d", i))
+	}
+	for _, mmap := range []bool{false, true} {
+		db, err := OpenWithOptions(dataPath, &Options{Mmap: mmap, Logger: &testLogger{}})
+		if err != nil {
+			b.Fatal(err)
+		}
+		name := "ReaderAt"
+		if mmap {
+			name = "Mmap"
+		}
+		b.Run(name+"/Get", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				db.Get(keys[i%n])
+			}
+		})
+		b.Run(name+"/GetInto", func(b *testing.B) {
+			b.ReportAllocs()
+			dst := make([]byte, 0, 64)
+			for i := 0; i < b.N; i++ {
+				dst, _ = db.GetInto(keys[i%n], dst[:0])
+			}
+		})
+		db.Close()
+	}
+}
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestOpenWithOptions(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.MkdirAll(testDir+"/data", 0755)
+	dataPath := testDir + "/data/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "123", "456")
+	file.Close()
+
+	indexDir := testDir + "/index"
+	_, err = OpenWithOptions(dataPath, &Options{IndexDir: indexDir, ReadOnly: true})
+	if !os.IsNotExist(err) {
+		t.Errorf("read-only open should fail when missing, %v", err)
+	}
+	_, err = OpenWithOptions(dataPath, &Options{IndexDir: indexDir, ErrorIfMissing: true})
+	if !os.IsNotExist(err) {
+		t.Errorf("open should fail when missing, %v", err)
+	}
+
+	logger := new(testLogger)
+	db, err := OpenWithOptions(dataPath, &Options{IndexDir: indexDir, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if len(logger.lines) == 0 {
+		t.Error("build should be logged")
+	}
+	if _, err := os.Stat(CurrentPath(testDir + "/data")); !os.IsNotExist(err) {
+		t.Error("nothing should be written next to the data file", err)
+	}
+
+	_, err = OpenWithOptions(dataPath, &Options{IndexDir: indexDir, ErrorIfExist: true})
+	if err != os.ErrExist {
+		t.Errorf("open should fail when exist, %v", err)
+	}
+	_, err = OpenWithOptions(dataPath, &Options{IndexDir: indexDir, ReadOnly: true, Rebuild: true})
+	if err != ErrReadOnly {
+		t.Errorf("read-only can't rebuild, %v", err)
+	}
+
+	for _, o := range []*Options{
+		{IndexDir: indexDir, ReadOnly: true},
+		{IndexDir: indexDir, ReadOnly: true, VerifyChecksums: true},
+		{IndexDir: indexDir, Rebuild: true, Logger: logger},
+	} {
+		db, err := OpenWithOptions(dataPath, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := db.Get([]byte("123"))
+		if err != nil || string(value) != "456" {
+			t.Errorf("%q should equal %q, %v", value, "456", err)
+		}
+		db.Close()
+	}
+}
+
+func TestOpenWithOptions_ShardBits(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "123", "456")
+	writeRecord(file, "helloworld", "!")
+	file.Close()
+
+	db, err := OpenWithOptions(dataPath, &Options{ShardBits: -1, OffsetWidth: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	manifest, err := loadManifest(testIndexDir)
+	if err != nil || manifest.ShardNum != 1 || manifest.OffsetWidth != 7 {
+		t.Fatalf("%+v should have 1 shard, %v", manifest, err)
+	}
+	if _, err := os.Stat(HashTablePath(GenerationPath(testIndexDir, manifest.BaseGeneration), 1)); !os.IsNotExist(err) {
+		t.Error("only one hash table should be built", err)
+	}
+
+	// the manifest decides the shard count when opening.
+	db, err = OpenWithOptions(dataPath, &Options{ShardBits: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	value, err := db.Get([]byte("helloworld"))
+	if err != nil || string(value) != "!" {
+		t.Errorf("%q should equal %q, %v", value, "!", err)
+	}
+}
+
+func TestOpenWithOptions_Hash(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	for i := 0; i < 100; i++ {
+		writeRecord(file, strconv.Itoa(i), strconv.Itoa(i*i))
+	}
+	file.Close()
+
+	for hash := HashFNV1; hash <= HashSipHash; hash++ {
+		seed := uint64(42)
+		db, err := OpenWithOptions(dataPath, &Options{Rebuild: true, Hash: hash, HashSeed: &seed})
+		if err != nil {
+			t.Fatal(hash, err)
+		}
+		db.Close()
+		manifest, err := loadManifest(testIndexDir)
+		if err != nil || manifest.Hash != hash || manifest.HashSeed != 42 {
+			t.Fatalf("%+v should be hashed by %v, %v", manifest, hash, err)
+		}
+		// the manifest decides the hash function when opening.
+		seed = 7
+		db, err = OpenWithOptions(dataPath, &Options{Hash: HashFNV1a, HashSeed: &seed})
+		if err != nil {
+			t.Fatal(hash, err)
+		}
+		for i := 0; i < 100; i++ {
+			value, err := db.Get([]byte(strconv.Itoa(i)))
+			if err != nil || string(value) != strconv.Itoa(i*i) {
+				t.Errorf("%v: %q should equal %q, %v", hash, value, strconv.Itoa(i*i), err)
+			}
+		}
+		db.Close()
+	}
+
+	// every index is built with a random seed by default.
+	var seeds []uint64
+	for i := 0; i < 2; i++ {
+		db, err := OpenWithOptions(dataPath, &Options{Rebuild: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+		manifest, err := loadManifest(testIndexDir)
+		if err != nil || manifest.Hash != DefaultHash || manifest.MaxProbeLength == 0 {
+			t.Fatalf("%+v should be hashed by %v, %v", manifest, DefaultHash, err)
+		}
+		seeds = append(seeds, manifest.HashSeed)
+	}
+	if seeds[0] == seeds[1] {
+		t.Errorf("%v should be random", seeds)
+	}
+
+	// seed 0 is the standard FNV-1.
+	var zero uint64
+	db, err := OpenWithOptions(dataPath, &Options{Rebuild: true, Hash: HashFNV1, HashSeed: &zero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	manifest, err := loadManifest(testIndexDir)
+	if err != nil || manifest.HashSeed != 0 {
+		t.Errorf("%+v should have seed 0, %v", manifest, err)
+	}
+	if hasher, _ := manifest.hasher(); hasher.Sum64([]byte("a")) != fnvHash64([]byte("a")) {
+		t.Errorf("%v should equal expected(%v)", hasher.Sum64([]byte("a")), fnvHash64([]byte("a")))
+	}
+
+	// the hash tables must be hashed by the hash function of the manifest.
+	manifest, _ = loadManifest(testIndexDir)
+	manifest.Hash = HashSipHash
+	CreateManifestFile(GenerationPath(testIndexDir, manifest.Generation), manifest)
+	if _, err := Open(dataPath); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("%v should equal expected(%v)", err, ErrHashMismatch)
+	}
+	manifest.Hash = HashSipHash + 1
+	CreateManifestFile(GenerationPath(testIndexDir, manifest.Generation), manifest)
+	if _, err := Open(dataPath); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("%v should equal expected(%v)", err, ErrUnknownHash)
+	}
+	if _, err := OpenWithOptions(dataPath, &Options{Rebuild: true, Hash: HashSipHash + 1}); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("%v should equal expected(%v)", err, ErrUnknownHash)
+	}
+
+	// the manifests of version 6 are hashed by FNV-1.
+	db, err = OpenWithOptions(dataPath, &Options{Rebuild: true, Hash: HashFNV1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	manifest, _ = loadManifest(testIndexDir)
+	manifest.Version, manifest.Hash = 6, 0
+	CreateManifestFile(GenerationPath(testIndexDir, manifest.Generation), manifest)
+	db, err = Open(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if value, err := db.Get([]byte("9")); err != nil || string(value) != "81" {
+		t.Errorf("%q should equal %q, %v", value, "81", err)
+	}
+}
+
+func TestOpen_Corrupt(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "123", "456")
+	file.Close()
+	db, err := OpenWithOptions(dataPath, &Options{ShardBits: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	openFiles := func() int {
+		fds, _ := os.ReadDir("/proc/self/fd")
+		return len(fds)
+	}
+	fds := openFiles()
+
+	for _, c := range []struct {
+		name    string
+		corrupt func(manifest *Manifest, dir string)
+		err     error
+	}{
+		{"missing shard", func(manifest *Manifest, dir string) {
+			os.Remove(HashTablePath(dir, 3))
+		}, ErrShardMissing},
+		{"truncated shard", func(manifest *Manifest, dir string) {
+			os.Truncate(HashTablePath(dir, 2), headerLen)
+		}, ErrCorruptIndex},
+		{"version", func(manifest *Manifest, dir string) {
+			manifest.Version = version + 1
+			CreateManifestFile(dir, manifest)
+		}, ErrVersionMismatch},
+		{"shard count", func(manifest *Manifest, dir string) {
+			manifest.ShardNum = 3
+			CreateManifestFile(dir, manifest)
+		}, ErrCorruptIndex},
+		{"manifest", func(manifest *Manifest, dir string) {
+			os.WriteFile(ManifestPath(dir), []byte("{"), 0644)
+		}, ErrCorruptIndex},
+		{"current", func(manifest *Manifest, dir string) {
+			os.WriteFile(CurrentPath(testIndexDir), []byte("x"), 0644)
+		}, ErrCorruptIndex},
+	} {
+		manifest, err := loadManifest(testIndexDir)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		c.corrupt(manifest, GenerationPath(testIndexDir, manifest.Generation))
+		for _, o := range []*Options{nil, {ReadOnly: true, RebuildIfCorrupt: true}} {
+			if _, err := OpenWithOptions(dataPath, o); !errors.Is(err, c.err) {
+				t.Errorf("%v: %v should equal expected(%v)", c.name, err, c.err)
+			}
+		}
+		if n := openFiles(); n != fds {
+			t.Errorf("%v: %v open files should equal expected(%v)", c.name, n, fds)
+		}
+		db, err := OpenWithOptions(dataPath, &Options{RebuildIfCorrupt: true, Logger: &testLogger{}})
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		value, err := db.Get([]byte("123"))
+		if err != nil || string(value) != "456" {
+			t.Errorf("%v: %q should equal %q, %v", c.name, value, "456", err)
+		}
+		db.Close()
+	}
+}
+
+func TestOpenWithOptions_Stale(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "123", "456")
+	file.Close()
+	db, err := Open(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// append a record
+	file, err = os.OpenFile(dataPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecord(file, "abc", "def")
+	file.Close()
+	if _, err := OpenWithOptions(dataPath, &Options{ReadOnly: true}); !errors.Is(err, ErrStaleIndex) {
+		t.Errorf("indexes should be stale, %v", err)
+	}
+	db, err = OpenWithOptions(dataPath, &Options{ReadOnly: true, StalePolicy: StaleIgnore})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte("abc")); err != os.ErrNotExist {
+		t.Error("stale indexes should not have the new key", err)
+	}
+	db.Close()
+	db, err = Open(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := db.Get([]byte("abc"))
+	if err != nil || string(value) != "def" {
+		t.Errorf("%q should equal %q, %v", value, "def", err)
+	}
+	db.Close()
+
+	// truncate the data
+	os.Truncate(dataPath, 22)
+	if _, err := OpenWithOptions(dataPath, &Options{StalePolicy: StaleError}); !errors.Is(err, ErrStaleIndex) {
+		t.Errorf("indexes should be stale, %v", err)
+	}
+	db, err = Open(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// modify the data in place and keep the size and mtime
+	info, err := os.Stat(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err = os.OpenFile(dataPath, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte("321"), 8)
+	file.Close()
+	os.Chtimes(dataPath, info.ModTime(), info.ModTime())
+	if _, err := OpenWithOptions(dataPath, &Options{StalePolicy: StaleError}); !errors.Is(err, ErrStaleIndex) {
+		t.Errorf("indexes should be stale, %v", err)
+	}
+}
+
+func TestOpen_Generations(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "123", "456")
+	file.Close()
+
+	// a crashed build leaves a staging generation without CURRENT.
+	os.Mkdir(testIndexDir, 0755)
+	os.Mkdir(GenerationPath(testIndexDir, 1), 0755)
+	os.WriteFile(HashTablePath(GenerationPath(testIndexDir, 1), 0), nil, 0644)
+	db, err := Open(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	generation, err := readCurrent(testIndexDir)
+	if err != nil || generation != 2 {
+		t.Fatalf("%v should equal expected(%v), %v", generation, 2, err)
+	}
+	if _, err := os.Stat(GenerationPath(testIndexDir, 1)); !os.IsNotExist(err) {
+		t.Error("the staging generation should be removed", err)
+	}
+
+	// a crashed rebuild leaves tmp files and a staging generation.
+	os.Mkdir(GenerationPath(testIndexDir, 3), 0755)
+	os.WriteFile(filepath.Join(GenerationPath(testIndexDir, 3), tmp+"0"), nil, 0644)
+	os.WriteFile(filepath.Join(GenerationPath(testIndexDir, 2), tmp+"0"), nil, 0644)
+	os.WriteFile(CurrentPath(testIndexDir)+".tmp", []byte("3"), 0644)
+	db, err = OpenWithOptions(dataPath, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := os.Stat(GenerationPath(testIndexDir, 3)); err != nil {
+		t.Error("read-only open should not remove anything", err)
+	}
+	db, err = Open(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := db.Get([]byte("123"))
+	if err != nil || string(value) != "456" {
+		t.Errorf("%q should equal %q, %v", value, "456", err)
+	}
+	db.Close()
+	entries, _ := os.ReadDir(testIndexDir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if fmt.Sprint(names) != fmt.Sprint([]string{current, "gen-2"}) {
+		t.Errorf("%v should only have the current generation", names)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(GenerationPath(testIndexDir, 2), tmp+"*")); len(tmps) != 0 {
+		t.Errorf("%v should be removed", tmps)
+	}
+
+	// rebuild publishes a new generation and removes the old one.
+	db, err = OpenWithOptions(dataPath, &Options{Rebuild: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := os.Stat(GenerationPath(testIndexDir, 2)); !os.IsNotExist(err) {
+		t.Error("the old generation should be removed", err)
+	}
+	if generation, _ := readCurrent(testIndexDir); generation != 3 {
+		t.Errorf("%v should equal expected(%v)", generation, 3)
+	}
+}
+
+func TestOpen_KeepsOtherFiles(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/tmpdata"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "123", "456")
+	others := []string{"tmpX", "tmp_user_backups/a", "gen-foo/b", "gen-01/c", "gen-9", "CURRENT.bak"}
+	for _, name := range others {
+		path := filepath.Join(testDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, nil, 0644)
+	}
+
+	// the indexes in a dedicated directory, and sharing the data directory.
+	for _, o := range []*Options{nil, {IndexDir: testDir}} {
+		db, err := OpenWithOptions(dataPath, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeRecord(file, "abc", "def")
+		if err := db.Refresh(); err != nil {
+			t.Error("refresh failed", err)
+		}
+		if err := db.Merge(); err != nil {
+			t.Error("merge failed", err)
+		}
+		db.Close()
+		for _, name := range append(others, "tmpdata") {
+			if _, err := os.Stat(filepath.Join(testDir, name)); err != nil {
+				t.Errorf("%v should be kept, %v", name, err)
+			}
+		}
+	}
+	file.Close()
+	if _, err := os.Stat(dataPath + IndexDirSuffix); err != nil {
+		t.Errorf("%v should be the default index directory, %v", dataPath+IndexDirSuffix, err)
+	}
+}
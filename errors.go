@@ -0,0 +1,54 @@
+package zyxindex
+
+import "errors"
+
+var (
+	// ErrReadOnly is returned when the indexes have to be written in read-only mode.
+	ErrReadOnly = errors.New("zyxindex: read-only mode")
+
+	// ErrInvalidShardBits is returned when the shard bits exceed MaxShardBits.
+	ErrInvalidShardBits = errors.New("zyxindex: invalid shard bits")
+
+	// ErrInvalidOffsetWidth is returned when the offset width is not in 5 to 8 bytes,
+	// or differs from the manifest.
+	ErrInvalidOffsetWidth = errors.New("zyxindex: invalid offset width")
+
+	// ErrOffsetOverflow is returned when the data file is too large for the offset width.
+	ErrOffsetOverflow = errors.New("zyxindex: offset overflow")
+
+	// ErrVersionMismatch is returned when the manifest is of an unsupported version.
+	ErrVersionMismatch = errors.New("zyxindex: version mismatch")
+
+	// ErrShardMissing is returned when a hash table of the manifest is missing.
+	ErrShardMissing = errors.New("zyxindex: shard missing")
+
+	// ErrCorruptIndex is returned when the manifest or a hash table is damaged,
+	// *CorruptionError matches it by errors.Is.
+	ErrCorruptIndex = errors.New("zyxindex: corrupt index")
+
+	// ErrStaleIndex is returned when the data file changed after the indexes were built.
+	ErrStaleIndex = errors.New("zyxindex: stale index")
+
+	// ErrUnknownHash is returned when a hash function is not built in.
+	ErrUnknownHash = errors.New("zyxindex: unknown hash function")
+
+	// ErrHashMismatch is returned when a hash table was built with another hash
+	// function than its manifest.
+	ErrHashMismatch = errors.New("zyxindex: hash function mismatch")
+
+	// ErrProbeTooLong is returned when a key of the hash tables built is probed
+	// more than Options.MaxProbeLength slots, the keys may be crafted to collide.
+	ErrProbeTooLong = errors.New("zyxindex: probe too long")
+
+	// ErrCorruptRecord is returned when the key size or the value size of a
+	// record exceeds Options.MaxKeySize or Options.MaxValueSize, see RecordError.
+	ErrCorruptRecord = errors.New("zyxindex: corrupt record")
+
+	// ErrValueTooLarge is returned when a value to read into memory exceeds
+	// Options.MaxValueSize or DefaultMaxReadSize, use OpenValue for it, or
+	// when the values of DB.MultiGetLimit exceed its limit.
+	ErrValueTooLarge = errors.New("zyxindex: value too large")
+
+	// ErrInvalidRange is returned when a range of a value has a negative offset or length.
+	ErrInvalidRange = errors.New("zyxindex: invalid range")
+)
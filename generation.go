@@ -0,0 +1,169 @@
+package zyxindex
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+	publish indexes atomically.
+
+	Every build, refresh and merge writes a new generation directory, which is
+	published by renaming the CURRENT file, so a crash never leaves a half-built
+	index in use:
+
+		{$dir}/CURRENT                        the current generation, e.g. "5"
+		{$dir}/gen-5/manifest                 the manifest of generation 5
+		{$dir}/gen-3/hashTable{$shardId}      the base shards, Manifest.BaseGeneration
+		{$dir}/gen-5/hashTable0               the delta, Manifest.DeltaGeneration
+
+	The generations not used by CURRENT, the tmp files in the generations and
+	CURRENT.tmp are removed by Open, nothing else in {$dir} is touched.
+*/
+
+const (
+	current          = "CURRENT"
+	generationPrefix = "gen-"
+)
+
+// CurrentPath returns the path of the CURRENT file in dir.
+func CurrentPath(dir string) string {
+	return filepath.Join(dir, current)
+}
+
+// GenerationPath returns the directory of generation in dir.
+func GenerationPath(dir string, generation int) string {
+	return filepath.Join(dir, generationPrefix+strconv.Itoa(generation))
+}
+
+// parseGeneration parses the generation of a directory name.
+func parseGeneration(name string) (generation int, ok bool) {
+	if !strings.HasPrefix(name, generationPrefix) {
+		return 0, false
+	}
+	generation, err := strconv.Atoi(name[len(generationPrefix):])
+	// only the names written by GenerationPath, not "gen-+1" or "gen-01".
+	return generation, err == nil && generation > 0 && name == generationPrefix+strconv.Itoa(generation)
+}
+
+// readCurrent reads the current generation of dir.
+// @return err, os.IsNotExist if there is no index in dir.
+func readCurrent(dir string) (generation int, err error) {
+	b, err := os.ReadFile(CurrentPath(dir))
+	if err != nil {
+		return
+	}
+	generation, err = strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || generation <= 0 {
+		return 0, fmt.Errorf("%w: invalid %s: %q", ErrCorruptIndex, CurrentPath(dir), b)
+	}
+	return
+}
+
+// newGeneration creates an empty directory for a generation newer than all
+// generations in dir.
+func newGeneration(dir string) (generation int, err error) {
+	generation, _ = readCurrent(dir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if g, ok := parseGeneration(entry.Name()); ok && g > generation {
+			generation = g
+		}
+	}
+	generation++
+	err = os.Mkdir(GenerationPath(dir, generation), 0755)
+	return
+}
+
+// publish writes the manifest into its generation, and makes it current.
+// All files of the generation must be written.
+func publish(dir string, manifest *Manifest) (err error) {
+	generationDir := GenerationPath(dir, manifest.Generation)
+	err = CreateManifestFile(generationDir, manifest)
+	if err != nil {
+		return
+	}
+	err = syncDir(generationDir)
+	if err != nil {
+		return
+	}
+
+	tmpPath := CurrentPath(dir) + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return
+	}
+	_, err = file.WriteString(strconv.Itoa(manifest.Generation) + "\n")
+	if err == nil {
+		err = file.Sync()
+	}
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmpPath, CurrentPath(dir))
+	if err != nil {
+		return
+	}
+	return syncDir(dir)
+}
+
+// removeObsolete removes the generations not used by manifest, and the tmp
+// files left by crashed builds. Only the names written by the indexes are
+// removed, dir may be shared with other files.
+func removeObsolete(dir string, manifest *Manifest) (err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		if name == current+".tmp" {
+			err = os.Remove(path)
+		} else if g, ok := parseGeneration(name); ok && entry.IsDir() {
+			if g == manifest.Generation || g == manifest.BaseGeneration ||
+				(manifest.DeltaEntries > 0 && g == manifest.DeltaGeneration) {
+				err = removeTmpFiles(path)
+			} else {
+				err = os.RemoveAll(path)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func removeTmpFiles(dir string) (err error) {
+	paths, err := filepath.Glob(filepath.Join(dir, tmp+"*"))
+	if err != nil {
+		return
+	}
+	for _, path := range paths {
+		err = os.Remove(path)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// syncDir commits the entries of dir.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
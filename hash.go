@@ -1,10 +1,268 @@
 package zyxindex
 
-import "hash/fnv"
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
 
-// fnv hash 64
+/*
+	hash the keys.
+
+	The hash function of the indexes is chosen by Options.Hash when they are
+	built, and recorded with its seed in the manifest, its id is also in the
+	header of every hash table. The indexes are always read with the hash
+	function of their manifest.
+
+	The keys of the data file may be crafted to share the slots of a hash
+	table, which turns a lookup into a scan of the shard. So every index is
+	built with a random seed by default, mixed into the hash of the keys.
+	The max probe length is recorded in the manifest, and the build fails if
+	it exceeds Options.MaxProbeLength, which is not limited by default: the
+	records of a key repeated many times fill a run of slots, which the
+	other keys homed in it probe past.
+*/
+
+// HashFunc identifies a built-in hash function of the keys.
+type HashFunc int
+
+const (
+	// HashFNV1 is the 64 bits FNV-1, the hash of the indexes built before
+	// the hash function was configurable. The seed is xored into the offset
+	// basis.
+	HashFNV1 HashFunc = 1 + iota
+	// HashFNV1a is the 64 bits FNV-1a. The seed is xored into the offset
+	// basis.
+	HashFNV1a
+	// HashXXH64 is xxHash64 with the seed.
+	HashXXH64
+	// HashSipHash is SipHash-2-4, keyed by the seed in both 64 bits halves
+	// of the key.
+	HashSipHash
+)
+
+// DefaultHash is the default of Options.Hash.
+const DefaultHash = HashXXH64
+
+// randomSeed returns a random seed of hashing.
+func randomSeed() (seed uint64, err error) {
+	var b [8]byte
+	_, err = rand.Read(b[:])
+	return binary.LittleEndian.Uint64(b[:]), err
+}
+
+func (h HashFunc) String() string {
+	switch h {
+	case HashFNV1:
+		return "fnv1"
+	case HashFNV1a:
+		return "fnv1a"
+	case HashXXH64:
+		return "xxh64"
+	case HashSipHash:
+		return "siphash"
+	}
+	return fmt.Sprintf("HashFunc(%d)", int(h))
+}
+
+// ParseHashFunc returns the hash function named s, as returned by String.
+// @return err, ErrUnknownHash if s is not a built-in hash function.
+func ParseHashFunc(s string) (h HashFunc, err error) {
+	for h = HashFNV1; h <= HashSipHash; h++ {
+		if h.String() == s {
+			return
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownHash, s)
+}
+
+// Hasher hashes the keys into 64 bits.
+type Hasher interface {
+	Sum64(key []byte) uint64
+}
+
+// NewHasher returns the Hasher of the hash function h with seed, the FNV
+// hashes with seed 0 are the standard ones.
+// @return err, ErrUnknownHash if h is not a built-in hash function.
+func NewHasher(h HashFunc, seed uint64) (hasher Hasher, err error) {
+	switch h {
+	case HashFNV1:
+		return fnv1Hasher{basis: fnvOffset64 ^ seed}, nil
+	case HashFNV1a:
+		return fnv1aHasher{basis: fnvOffset64 ^ seed}, nil
+	case HashXXH64:
+		return xxh64Hasher{seed: seed}, nil
+	case HashSipHash:
+		return sipHasher{k0: seed, k1: seed}, nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnknownHash, int(h))
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// fnv hash 64, the same as hash/fnv.New64 without allocations.
 func fnvHash64(key []byte) uint64 {
-	hash := fnv.New64()
-	hash.Write(key)
-	return hash.Sum64()
+	return fnv1(fnvOffset64, key)
+}
+
+func fnv1(basis uint64, key []byte) uint64 {
+	hash := basis
+	for _, c := range key {
+		hash *= fnvPrime64
+		hash ^= uint64(c)
+	}
+	return hash
+}
+
+func fnv1a(basis uint64, key []byte) uint64 {
+	hash := basis
+	for _, c := range key {
+		hash ^= uint64(c)
+		hash *= fnvPrime64
+	}
+	return hash
+}
+
+type fnv1Hasher struct {
+	basis uint64
+}
+
+func (h fnv1Hasher) Sum64(key []byte) uint64 { return fnv1(h.basis, key) }
+
+type fnv1aHasher struct {
+	basis uint64
+}
+
+func (h fnv1aHasher) Sum64(key []byte) uint64 { return fnv1a(h.basis, key) }
+
+type xxh64Hasher struct {
+	seed uint64
+}
+
+func (h xxh64Hasher) Sum64(key []byte) uint64 { return xxh64(key, h.seed) }
+
+type sipHasher struct {
+	k0, k1 uint64
+}
+
+func (h sipHasher) Sum64(key []byte) uint64 { return sipHash24(h.k0, h.k1, key) }
+
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+// xxh64 is xxHash64 of b with seed.
+func xxh64(b []byte, seed uint64) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		v1 := seed + xxhPrime1 + xxhPrime2
+		v2 := seed + xxhPrime2
+		v3 := seed
+		v4 := seed - xxhPrime1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxhRound(v1, binary.LittleEndian.Uint64(b[0:]))
+			v2 = xxhRound(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = xxhRound(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = xxhRound(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxhMergeRound(h, v1)
+		h = xxhMergeRound(h, v2)
+		h = xxhMergeRound(h, v3)
+		h = xxhMergeRound(h, v4)
+	} else {
+		h = seed + xxhPrime5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxhPrime1
+		h = bits.RotateLeft64(h, 23)*xxhPrime2 + xxhPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxhPrime5
+		h = bits.RotateLeft64(h, 11) * xxhPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxhPrime2
+	h ^= h >> 29
+	h *= xxhPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * xxhPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxhPrime1
+}
+
+func xxhMergeRound(acc, val uint64) uint64 {
+	acc ^= xxhRound(0, val)
+	return acc*xxhPrime1 + xxhPrime4
+}
+
+// sipHash24 is SipHash-2-4 of b with the key (k0, k1).
+func sipHash24(k0, k1 uint64, b []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	n := len(b)
+	for ; len(b) >= 8; b = b[8:] {
+		m := binary.LittleEndian.Uint64(b)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	// the last block is the rest bytes and the length in the high byte.
+	m := uint64(n) << 56
+	for i, c := range b {
+		m |= uint64(c) << (8 * uint(i))
+	}
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
 }
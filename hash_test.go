@@ -0,0 +1,92 @@
+package zyxindex
+
+import (
+	"errors"
+	"hash/fnv"
+	"testing"
+)
+
+func TestFnvHash64(t *testing.T) {
+	for _, key := range []string{"", "a", "123", "helloworld"} {
+		hash := fnv.New64()
+		hash.Write([]byte(key))
+		if v := fnvHash64([]byte(key)); v != hash.Sum64() {
+			t.Errorf("%v: %v should equal expected(%v)", key, v, hash.Sum64())
+		}
+		hash = fnv.New64a()
+		hash.Write([]byte(key))
+		if v := (fnv1aHasher{basis: fnvOffset64}).Sum64([]byte(key)); v != hash.Sum64() {
+			t.Errorf("%v: %v should equal expected(%v)", key, v, hash.Sum64())
+		}
+	}
+	if allocs := testing.AllocsPerRun(100, func() { fnvHash64([]byte("a")) }); allocs != 0 {
+		t.Errorf("%v allocs should be 0", allocs)
+	}
+}
+
+func TestXXH64(t *testing.T) {
+	for _, c := range []struct {
+		key  string
+		hash uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"asdf", 0x415872f599cea71e},
+		{"Call me Ishmael. Some years ago--never mind how long precisely-", 0x02a2e85470d6fd96},
+	} {
+		if v := xxh64([]byte(c.key), 0); v != c.hash {
+			t.Errorf("%v: %x should equal expected(%x)", c.key, v, c.hash)
+		}
+	}
+	if xxh64([]byte("a"), 1) == xxh64([]byte("a"), 0) {
+		t.Errorf("the seed should change the hash")
+	}
+}
+
+func TestSipHash24(t *testing.T) {
+	// the test vectors of the SipHash paper, the key is 00 01 02 ... 0f.
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	msg := make([]byte, 15)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	for _, c := range []struct {
+		n    int
+		hash uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{8, 0x93f5f5799a932462},
+		{15, 0xa129ca6149be45e5},
+	} {
+		if v := sipHash24(k0, k1, msg[:c.n]); v != c.hash {
+			t.Errorf("%v: %x should equal expected(%x)", c.n, v, c.hash)
+		}
+	}
+}
+
+func TestNewHasher(t *testing.T) {
+	key := []byte("helloworld")
+	for h := HashFNV1; h <= HashSipHash; h++ {
+		hasher, err := NewHasher(h, 1)
+		if err != nil {
+			t.Fatal(h, err)
+		}
+		if allocs := testing.AllocsPerRun(100, func() { hasher.Sum64(key) }); allocs != 0 {
+			t.Errorf("%v: %v allocs should be 0", h, allocs)
+		}
+	}
+	if _, err := NewHasher(HashSipHash+1, 0); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("%v should equal expected(%v)", err, ErrUnknownHash)
+	}
+}
+
+func TestParseHashFunc(t *testing.T) {
+	for h := HashFNV1; h <= HashSipHash; h++ {
+		if v, err := ParseHashFunc(h.String()); err != nil || v != h {
+			t.Errorf("%v should equal expected(%v), %v", v, h, err)
+		}
+	}
+	if _, err := ParseHashFunc("md5"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("%v should equal expected(%v)", err, ErrUnknownHash)
+	}
+}
@@ -1,34 +1,53 @@
 package zyxindex
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"sort"
 )
 
 /*
  Hashtable is the implementation of HashTabler,
- Hashtable is closed chain hash table: the key is fixed 7 bytes and the value is fixed 5 bytes.
+ Hashtable is closed chain hash table: the key is fixed 7 bytes and the value is 5 to 8 bytes,
+ the value width is fixed in one hashTable.
 
  Generate a hashTable from a shardfile:
-  hashTable, err := Generate(source, keycount, writer);
+  hashTable, err := Generate(source, keycount, vLen, writer);
  Open a hashTable:
   hashTable, err := HashTable.Open(reader);
  Get from hashTable:
   value, err := hashTable.Get(key);
+ Get all values of a key from hashTable:
+  values, err := hashTable.Gets(key);
 
  The HashTable structure:
 
-		+--------------+--------------+--------------+--------------+--------------+ --------------+
-		|  slot count  |     slot 1   |  slot 2      |    ......    |    slot n    | TODO: checksum|
-		+--------------+--------------+--------------+--------------+--------------+ --------------+
+		+--------------+--------------+--------------+--------------+--------------+--------------+
+		|  header(32)  |     slot 1   |  slot 2      |    ......    |    slot n    |  footer(8)   |
+		+--------------+--------------+--------------+--------------+--------------+--------------+
+
+ The header structure, integers are little endian:
+
+		+----------+-----------+---------+----------+----------+---------+----------+------------+-------------+
+		| magic(8) | format(2) | slot(1) | key(1)   | value(1) | hash(1) | zero(2)  | slot count | entry count |
+		|          |  version  |  width  |  width   |  width   |   id    |          |    (8)     |     (8)     |
+		+----------+-----------+---------+----------+----------+---------+----------+------------+-------------+
+
+ The footer structure, checksum is the CRC32C over all slots:
+
+		+--------------+--------------+
+		| checksum(4)  |   magic(4)   |
+		+--------------+--------------+
 
- The slot count is 8 bytes. belows
  The slot structure:
 
 		+--------------+--------------+
-		|    key(7)    |  value(5)    |
+		|    key(7)    |  value(vLen) |
 		+--------------+--------------+
 
 
@@ -37,21 +56,127 @@ import (
 
 const (
 	kLen = 7
-	vLen = 5
+	// the value is the offset in data file, 5 bytes for 1TB.
+	minVLen = 5
+	maxVLen = 8
+
+	headerLen = 32
+	footerLen = 8
+
+	hashTableMagic  = "ZYXHTABL"
+	footerMagic     = "ZYXF"
+	hashTableFormat = 1
 )
 
-// NotExistSlot when a slot has nothing.
-var NotExistSlot = make([]byte, kLen+vLen)
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptionError is returned when an index file is truncated, foreign or damaged.
+type CorruptionError struct {
+	// File is the name of the file if known.
+	File   string
+	Reason string
+}
+
+// Is makes CorruptionError match ErrCorruptIndex.
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorruptIndex
+}
+
+func (e *CorruptionError) Error() string {
+	if e.File == "" {
+		return "zyxindex: corrupt hash table: " + e.Reason
+	}
+	return "zyxindex: corrupt hash table " + e.File + ": " + e.Reason
+}
+
+// newCorruptionError names the file by r if r is a file.
+func newCorruptionError(r io.ReaderAt, format string, v ...interface{}) *CorruptionError {
+	e := &CorruptionError{Reason: fmt.Sprintf(format, v...)}
+	if f, ok := r.(interface{ Name() string }); ok {
+		e.File = f.Name()
+	}
+	return e
+}
+
+// tableHeader is the header of a hash table file.
+type tableHeader struct {
+	format     uint16
+	slotLen    int
+	kLen       int
+	vLen       int
+	hashID     HashFunc
+	slotCount  uint64
+	entryCount uint64
+}
+
+func (th *tableHeader) encode() []byte {
+	b := make([]byte, headerLen)
+	copy(b, hashTableMagic)
+	binary.LittleEndian.PutUint16(b[8:], th.format)
+	b[10] = byte(th.slotLen)
+	b[11] = byte(th.kLen)
+	b[12] = byte(th.vLen)
+	b[13] = byte(th.hashID)
+	binary.LittleEndian.PutUint64(b[16:], th.slotCount)
+	binary.LittleEndian.PutUint64(b[24:], th.entryCount)
+	return b
+}
 
-func init() {
+// decodeTableHeader decodes and validates a header.
+// @return reason, not empty if the header is invalid.
+func decodeTableHeader(b []byte) (th tableHeader, reason string) {
+	if string(b[:8]) != hashTableMagic {
+		return th, "bad magic"
+	}
+	th = tableHeader{
+		format:     binary.LittleEndian.Uint16(b[8:]),
+		slotLen:    int(b[10]),
+		kLen:       int(b[11]),
+		vLen:       int(b[12]),
+		hashID:     HashFunc(b[13]),
+		slotCount:  binary.LittleEndian.Uint64(b[16:]),
+		entryCount: binary.LittleEndian.Uint64(b[24:]),
+	}
+	switch {
+	case th.format != hashTableFormat:
+		return th, fmt.Sprintf("unsupported format version %d", th.format)
+	case th.kLen != kLen:
+		return th, fmt.Sprintf("invalid key width %d", th.kLen)
+	case th.vLen < minVLen || th.vLen > maxVLen:
+		return th, fmt.Sprintf("invalid value width %d", th.vLen)
+	case th.slotLen != th.kLen+th.vLen:
+		return th, fmt.Sprintf("invalid slot width %d", th.slotLen)
+	case th.hashID < HashFNV1 || th.hashID > HashSipHash:
+		return th, fmt.Sprintf("unknown hash id %d", th.hashID)
+	case th.slotCount == 0 || th.slotCount&(th.slotCount-1) != 0:
+		return th, fmt.Sprintf("invalid slot count %d", th.slotCount)
+	case th.entryCount > th.slotCount:
+		return th, fmt.Sprintf("entry count %d exceeds slot count %d", th.entryCount, th.slotCount)
+	}
+	return th, ""
+}
+
+// notExistSlot returns the slot which has nothing.
+func notExistSlot(vLen int) []byte {
+	slot := make([]byte, kLen+vLen)
 	for i := kLen; i < kLen+vLen; i++ {
-		NotExistSlot[i] = 0xf
+		slot[i] = 0xf
 	}
+	return slot
 }
 
 type HashTable struct {
-	slotCount uint64
-	r         io.ReaderAt
+	slotCount  uint64
+	entryCount uint64
+	vLen       int
+	hash       HashFunc
+	r          io.ReaderAt
+
+	// checksum in the footer
+	checksum uint32
+
+	// notExistSlot of vLen
+	notExistSlot []byte
 }
 
 type KV struct {
@@ -75,67 +200,363 @@ func nextSlot(slot uint64, slotCount uint64) uint64 {
 // Generate generates a HashTable of a shard.
 // @param source [in], a shard kv reader.
 // @param keycount [in], key count of the reader.
+// @param vLen [in], the value width, from 5 to 8 bytes.
 // @param w [out], implements the file writer of the HashTable.
 // @return err, nil means success, other means fail.
-func Generate(source kvReader, keycount int, w io.Writer) (err error) {
+func Generate(source kvReader, keycount int, vLen int, w io.Writer) (err error) {
+	_, err = generate(source, keycount, vLen, HashFNV1, w, 0)
+	return
+}
+
+// kvRewinder is a kvReader which can be read again from the first kv.
+type kvRewinder interface {
+	kvReader
+	rewind() error
+}
+
+// generate is Generate with at most budget bytes of slots in memory, if
+// budget is positive. The slots exceeding budget are generated in several
+// passes over source, which must be a kvRewinder, and written into w by
+// WriteAt, w must be an io.WriterAt and an io.ReaderAt, else budget is
+// ignored. hash is the hash function of the keys recorded in the header.
+// @return maxProbe, the most slots probed to find the first value of a key.
+func generate(source kvReader, keycount int, vLen int, hash HashFunc, w io.Writer, budget int64) (maxProbe int, err error) {
+	if vLen < minVLen || vLen > maxVLen {
+		return 0, ErrInvalidOffsetWidth
+	}
 	slotCount := uint64(keycount * 3)
 	musk := uint64(0)
 	for ; 1<<musk < slotCount; musk++ {
 	}
 	slotCount = 1 << musk
+	slotLen := uint64(kLen + vLen)
+	header := &tableHeader{
+		format:     hashTableFormat,
+		slotLen:    int(slotLen),
+		kLen:       kLen,
+		vLen:       vLen,
+		hashID:     hash,
+		slotCount:  slotCount,
+		entryCount: uint64(keycount),
+	}
 
-	slots := make([][]byte, slotCount)
-	hit := make([]bool, slotCount)
+	if budget > 0 && uint64(budget) < slotCount*slotLen {
+		rewinder, ok := source.(kvRewinder)
+		file, ok2 := w.(interface {
+			io.WriterAt
+			io.ReaderAt
+		})
+		if ok && ok2 {
+			return generatePasses(rewinder, header, file, uint64(budget)/slotLen)
+		}
+	}
 
+	t := newSlotTable(slotCount, slotLen)
+	t.setRegion(0, slotCount)
+	entry := make([]byte, slotLen)
 	for i := 0; i < keycount; i++ {
-		slotData := make([]byte, kLen+vLen)
-		k, v := slotData[:kLen], slotData[kLen:]
-		err = source.readNext(k, v)
+		err = source.readNext(entry[:kLen], entry[kLen:])
 		if err != nil {
 			return
 		}
-
-		slot := littleEndianKey(k) & (slotCount - 1)
-		for j := uint64(0); j < slotCount; j++ {
-			if !hit[slot] {
-				hit[slot] = true
-				slots[slot] = slotData
-				break
-			}
-			slot = nextSlot(slot, slotCount)
+		home := littleEndianKey(entry) & (slotCount - 1)
+		if ok, seen := t.insert(home, entry, false); !ok {
+			// run off the last slot, wrap to the first.
+			t.insert(0, entry, seen)
 		}
 	}
+	t.fillEmpty(vLen)
+	maxProbe = int(t.maxProbe)
 
 	// flush
-	slotCountB := make([]byte, 8)
-	binary.LittleEndian.PutUint64(slotCountB, slotCount)
-	_, err = w.Write(slotCountB)
+	_, err = w.Write(header.encode())
+	if err != nil {
+		return
+	}
+	crc := crc32.New(castagnoli)
+	_, err = io.MultiWriter(w, crc).Write(t.region)
 	if err != nil {
 		return
 	}
+	_, err = w.Write(encodeFooter(crc.Sum32()))
+	return
+}
 
-	for i, slot := range slots {
-		if hit[i] {
-			_, err = w.Write(slot)
-		} else {
-			_, err = w.Write(NotExistSlot)
+// generatePasses generates the slots in passes of passSlots slots, the
+// entries probing beyond a pass are carried into the next pass, and the
+// entries probing beyond the last slot wrap to the free slots from the first.
+func generatePasses(source kvRewinder, header *tableHeader, w interface {
+	io.WriterAt
+	io.ReaderAt
+}, passSlots uint64) (maxProbe int, err error) {
+	slotCount, slotLen := header.slotCount, uint64(header.slotLen)
+	if passSlots == 0 {
+		passSlots = 1
+	}
+	_, err = w.WriteAt(header.encode(), 0)
+	if err != nil {
+		return
+	}
+	t := newSlotTable(slotCount, slotLen)
+	// carried is an entry probing beyond a pass, seen is true if an entry of
+	// the same key is before it.
+	type carried struct {
+		entry []byte
+		seen  bool
+	}
+	var carry []carried
+	entry := make([]byte, slotLen)
+	for lo := uint64(0); lo < slotCount; lo += passSlots {
+		hi := lo + passSlots
+		if hi > slotCount {
+			hi = slotCount
+		}
+		t.setRegion(lo, hi)
+		var next []carried
+		for _, c := range carry {
+			if ok, seen := t.insert(lo, c.entry, c.seen); !ok {
+				next = append(next, carried{c.entry, seen})
+			}
+		}
+		err = source.rewind()
+		if err != nil {
+			return
+		}
+		for i := uint64(0); i < header.entryCount; i++ {
+			err = source.readNext(entry[:kLen], entry[kLen:])
+			if err != nil {
+				return
+			}
+			home := littleEndianKey(entry) & (slotCount - 1)
+			if home < lo || home >= hi {
+				continue
+			}
+			if ok, seen := t.insert(home, entry, false); !ok {
+				next = append(next, carried{append([]byte(nil), entry...), seen})
+			}
 		}
+		t.fillEmpty(header.vLen)
+		_, err = w.WriteAt(t.region, int64(headerLen+lo*slotLen))
 		if err != nil {
 			return
 		}
+		carry = next
 	}
+	// the first slots have no entries of the same keys, keep the values of
+	// a key ascending among the carried entries.
+	sort.SliceStable(carry, func(i, j int) bool {
+		return littleEndianOffset(carry[i].entry[kLen:]) < littleEndianOffset(carry[j].entry[kLen:])
+	})
+	// the first placed entry of a key is its first entry.
+	unseen := map[uint64]bool{}
+	for _, c := range carry {
+		if !c.seen {
+			unseen[littleEndianKey(c.entry)] = true
+		}
+	}
+	slot := uint64(0)
+	for _, c := range carry {
+		for ; t.isUsed(slot); slot++ {
+		}
+		t.setUsed(slot)
+		if key := littleEndianKey(c.entry); unseen[key] {
+			t.probed(slot, c.entry)
+			delete(unseen, key)
+		}
+		_, err = w.WriteAt(c.entry, int64(headerLen+slot*slotLen))
+		if err != nil {
+			return
+		}
+	}
+	maxProbe = int(t.maxProbe)
+
+	// the slots are written, read them back for the checksum.
+	crc := crc32.New(castagnoli)
+	_, err = io.Copy(crc, io.NewSectionReader(w, headerLen, int64(slotCount*slotLen)))
+	if err != nil {
+		return
+	}
+	_, err = w.WriteAt(encodeFooter(crc.Sum32()), int64(headerLen+slotCount*slotLen))
 	return
 }
 
+func encodeFooter(checksum uint32) []byte {
+	footer := make([]byte, footerLen)
+	binary.LittleEndian.PutUint32(footer, checksum)
+	copy(footer[4:], footerMagic)
+	return footer
+}
+
+// slotTable holds the slots in [lo, hi) of a hash table being generated in
+// one contiguous array, the used slots of the whole table are in a bitmap.
+type slotTable struct {
+	slotLen uint64
+	mask    uint64
+	used    []uint64
+	buf     []byte
+	// maxProbe is the most slots probed to find the first entry of a key.
+	maxProbe uint64
+
+	lo, hi uint64
+	region []byte
+	tmp    []byte
+}
+
+func newSlotTable(slotCount, slotLen uint64) *slotTable {
+	return &slotTable{
+		slotLen: slotLen,
+		mask:    slotCount - 1,
+		used:    make([]uint64, (slotCount+63)/64),
+		tmp:     make([]byte, slotLen),
+	}
+}
+
+// setRegion starts holding the slots in [lo, hi).
+func (t *slotTable) setRegion(lo, hi uint64) {
+	size := (hi - lo) * t.slotLen
+	if uint64(cap(t.buf)) < size {
+		t.buf = make([]byte, size)
+	}
+	t.lo, t.hi, t.region = lo, hi, t.buf[:size]
+}
+
+func (t *slotTable) isUsed(slot uint64) bool {
+	return t.used[slot/64]&(1<<(slot%64)) != 0
+}
+
+func (t *slotTable) setUsed(slot uint64) {
+	t.used[slot/64] |= 1 << (slot % 64)
+}
+
+// insert puts entry into the first free slot from slot to hi. The values of
+// a key are kept ascending in probe order, which is the file order of the
+// records, parallel builds put them in any order.
+// seen is true if an entry of the same key is before slot in the probe
+// order, the probe length of the first entry of every key is recorded.
+// It returns false if no slot is free, then entry is the entry displaced
+// from the last slot, and seen is for it.
+func (t *slotTable) insert(slot uint64, entry []byte, seen bool) (ok bool, seenKey bool) {
+	for ; slot < t.hi; slot++ {
+		b := t.region[(slot-t.lo)*t.slotLen : (slot-t.lo+1)*t.slotLen]
+		if !t.isUsed(slot) {
+			t.setUsed(slot)
+			copy(b, entry)
+			if !seen {
+				t.probed(slot, entry)
+			}
+			return true, seen
+		}
+		if bytes.Equal(b[:kLen], entry[:kLen]) {
+			seen = true
+			if littleEndianOffset(b[kLen:]) > littleEndianOffset(entry[kLen:]) {
+				copy(t.tmp, b)
+				copy(b, entry)
+				copy(entry, t.tmp)
+			}
+		}
+	}
+	return false, seen
+}
+
+// probed records the probe length of entry in slot.
+func (t *slotTable) probed(slot uint64, entry []byte) {
+	n := (slot-littleEndianKey(entry))&t.mask + 1
+	if n > t.maxProbe {
+		t.maxProbe = n
+	}
+}
+
+// fillEmpty fills the free slots of the region with notExistSlot.
+func (t *slotTable) fillEmpty(vLen int) {
+	empty := notExistSlot(vLen)
+	for slot := t.lo; slot < t.hi; slot++ {
+		if !t.isUsed(slot) {
+			copy(t.region[(slot-t.lo)*t.slotLen:], empty)
+		}
+	}
+}
+
 // Open opens a hash table from a file, which implements the io.ReaderAt
-func OpenHashTable(r io.ReaderAt) (h *HashTable, err error) {
-	slotCountB := make([]byte, 8)
-	_, err = r.ReadAt(slotCountB, 0)
+// The header and the footer are always checked, the checksum of slots is
+// checked only if verify is true.
+// @return err, *CorruptionError if the file is truncated, foreign or damaged.
+func OpenHashTable(r io.ReaderAt, verify bool) (h *HashTable, err error) {
+	header := make([]byte, headerLen)
+	_, err = r.ReadAt(header, 0)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, newCorruptionError(r, "truncated header")
+	}
+	if err != nil {
+		return
+	}
+	th, reason := decodeTableHeader(header)
+	if reason != "" {
+		return nil, newCorruptionError(r, "%s", reason)
+	}
+
+	footer := make([]byte, footerLen)
+	_, err = r.ReadAt(footer, headerLen+int64(th.slotCount)*int64(th.slotLen))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, newCorruptionError(r, "truncated slots")
+	}
 	if err != nil {
 		return
 	}
-	slotCount := binary.LittleEndian.Uint64(slotCountB)
-	return &HashTable{slotCount: slotCount, r: r}, nil
+	if string(footer[4:]) != footerMagic {
+		return nil, newCorruptionError(r, "bad footer magic")
+	}
+
+	h = &HashTable{
+		slotCount:    th.slotCount,
+		entryCount:   th.entryCount,
+		vLen:         th.vLen,
+		hash:         th.hashID,
+		r:            r,
+		checksum:     binary.LittleEndian.Uint32(footer),
+		notExistSlot: notExistSlot(th.vLen),
+	}
+	if verify {
+		err = h.Verify()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Verify reads all slots and checks them against the checksum in the footer.
+// @return err, *CorruptionError if the checksum mismatches.
+func (h *HashTable) Verify() (err error) {
+	crc := crc32.New(castagnoli)
+	slots := io.NewSectionReader(h.r, headerLen, int64(h.slotCount)*int64(kLen+h.vLen))
+	_, err = io.Copy(crc, slots)
+	if err != nil {
+		return
+	}
+	if crc.Sum32() != h.checksum {
+		return newCorruptionError(h.r, "checksum mismatch")
+	}
+	return
+}
+
+// ValueLen returns the value width of the hash table.
+func (h *HashTable) ValueLen() int {
+	return h.vLen
+}
+
+// HashFunc returns the hash function of the keys in the hash table.
+func (h *HashTable) HashFunc() HashFunc {
+	return h.hash
+}
+
+// SlotCount returns the slots of the hash table.
+func (h *HashTable) SlotCount() uint64 {
+	return h.slotCount
+}
+
+// EntryCount returns the entries of the hash table.
+func (h *HashTable) EntryCount() uint64 {
+	return h.entryCount
 }
 
 // Get gets value of the key from hash table
@@ -144,14 +565,15 @@ func OpenHashTable(r io.ReaderAt) (h *HashTable, err error) {
 // @return err, nil when the key exists, os.ErrNotExist when the key miss. or return other
 func (h *HashTable) Get(k []byte) (v []byte, err error) {
 	slot := littleEndianKey(k) & (h.slotCount - 1)
-	b := make([]byte, kLen+vLen)
+	slotLen := uint64(kLen + h.vLen)
+	b := make([]byte, slotLen)
 	for i := uint64(0); i < h.slotCount; i++ {
-		off := int64(8 + slot*(kLen+vLen))
+		off := int64(headerLen + slot*slotLen)
 		_, err = h.r.ReadAt(b, off)
 		if err != nil {
 			return
 		}
-		if bytes.Equal(b, NotExistSlot) {
+		if bytes.Equal(b, h.notExistSlot) {
 			return nil, os.ErrNotExist
 		}
 		if bytes.Equal(b[:kLen], k) {
@@ -162,6 +584,87 @@ func (h *HashTable) Get(k []byte) (v []byte, err error) {
 	return nil, os.ErrNotExist
 }
 
+// probe implements offsetProber, buf holds the slot read.
+func (h *HashTable) probe(k []byte, i uint64, buf []byte) (offset uint64, next uint64, err error) {
+	slotLen := uint64(kLen + h.vLen)
+	b := buf[:slotLen]
+	slot := (littleEndianKey(k) + i) & (h.slotCount - 1)
+	for ; i < h.slotCount; i++ {
+		_, err = h.r.ReadAt(b, int64(headerLen+slot*slotLen))
+		if err != nil {
+			return
+		}
+		if bytes.Equal(b, h.notExistSlot) {
+			return 0, i, os.ErrNotExist
+		}
+		if bytes.Equal(b[:kLen], k) {
+			return littleEndianOffset(b[kLen:]), i + 1, nil
+		}
+		slot = nextSlot(slot, h.slotCount)
+	}
+	return 0, i, os.ErrNotExist
+}
+
+// Gets gets all values of the key from hash table.
+// Gets does not stop at the first matched slot, it probes until an empty slot,
+// so the values are returned in ascending order.
+// @param k, the key
+// @return vs, the values
+// @return err, nil when the key exists, os.ErrNotExist when the key miss. or return other
+func (h *HashTable) Gets(k []byte) (vs [][]byte, err error) {
+	slot := littleEndianKey(k) & (h.slotCount - 1)
+	slotLen := uint64(kLen + h.vLen)
+	for i := uint64(0); i < h.slotCount; i++ {
+		b := make([]byte, slotLen)
+		off := int64(headerLen + slot*slotLen)
+		_, err = h.r.ReadAt(b, off)
+		if err != nil {
+			return
+		}
+		if bytes.Equal(b, h.notExistSlot) {
+			break
+		}
+		if bytes.Equal(b[:kLen], k) {
+			vs = append(vs, b[kLen:])
+		}
+		slot = nextSlot(slot, h.slotCount)
+	}
+	if len(vs) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return
+}
+
+// Range calls fn with the key and the value of every non-empty slot, in slot
+// order. Range stops and returns the error if fn returns an error.
+// k and v are only valid during fn.
+func (h *HashTable) Range(fn func(k, v []byte) error) (err error) {
+	return h.RangeSlots(func(slot uint64, k, v []byte) error {
+		return fn(k, v)
+	})
+}
+
+// RangeSlots is Range with the slot index of every k and v.
+func (h *HashTable) RangeSlots(fn func(slot uint64, k, v []byte) error) (err error) {
+	slotLen := int64(kLen + h.vLen)
+	slots := bufio.NewReader(io.NewSectionReader(h.r, headerLen, int64(h.slotCount)*slotLen))
+	b := make([]byte, slotLen)
+	for i := uint64(0); i < h.slotCount; i++ {
+		_, err = io.ReadFull(slots, b)
+		if err != nil {
+			return
+		}
+		if bytes.Equal(b, h.notExistSlot) {
+			continue
+		}
+		err = fn(i, b[:kLen], b[kLen:])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
 func (h *HashTable) Close() error {
 	if closer, ok := h.r.(io.Closer); ok {
 		return closer.Close()
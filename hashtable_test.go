@@ -3,7 +3,10 @@ package zyxindex
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"io"
 	"os"
+	"sort"
 	"testing"
 )
 
@@ -17,7 +20,7 @@ func (s *Source) readNext(k, v []byte) (err error) {
 	b := make([]byte, 8)
 	binary.LittleEndian.PutUint64(b, uint64(key))
 	copy(k, b[:kLen])
-	copy(v, b[:vLen])
+	copy(v, b)
 	s.index++
 	return nil
 }
@@ -28,12 +31,12 @@ func TestHashTable(t *testing.T) {
 	}
 	buffer := new(bytes.Buffer)
 	N := len(source.keys)
-	err := Generate(source, N, buffer)
+	err := Generate(source, N, minVLen, buffer)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	h, err := OpenHashTable(bytes.NewReader(buffer.Bytes()))
+	h, err := OpenHashTable(bytes.NewReader(buffer.Bytes()), true)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -55,10 +58,238 @@ func TestHashTable(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			expected := b[:vLen]
+			expected := b[:minVLen]
 			if !bytes.Equal(v[:], expected) {
 				t.Errorf("%v: data is not equal", key)
 			}
 		}
 	}
 }
+
+func TestHashTable_Gets(t *testing.T) {
+	source := &Source{
+		keys: []int{1, 2, 1, 17, 1},
+	}
+	buffer := new(bytes.Buffer)
+	err := Generate(source, len(source.keys), minVLen, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := OpenHashTable(bytes.NewReader(buffer.Bytes()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, 1)
+	vs, err := h.Gets(b[:kLen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 3 {
+		t.Errorf("%v should have 3 values", vs)
+	}
+	binary.LittleEndian.PutUint64(b, 7)
+	if _, err := h.Gets(b[:kLen]); err != os.ErrNotExist {
+		t.Errorf("7: data shoule not exist, %v", err)
+	}
+}
+
+type offsetSource struct {
+	keys    []uint64
+	offsets []uint64
+	index   int
+}
+
+func (s *offsetSource) readNext(k, v []byte) (err error) {
+	littleEndianPutKey(k, s.keys[s.index])
+	littleEndianPutOffset(v, s.offsets[s.index])
+	s.index++
+	return nil
+}
+
+func (s *offsetSource) rewind() error {
+	s.index = 0
+	return nil
+}
+
+func TestGenerate_Passes(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	// 128 slots, the keys of the last slot wrap to the first slots.
+	source := &offsetSource{}
+	for i := uint64(0); i < 10; i++ {
+		source.keys = append(source.keys, 127+i%3<<20, i*7%5, i*3+8)
+		source.offsets = append(source.offsets, 100-i, 100+i, i)
+	}
+	file, err := os.Create(testDir + "/table")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	slotLen := int64(kLen + minVLen)
+	maxProbe, err := generate(source, len(source.keys), minVLen, HashFNV1, file, 5*slotLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := OpenHashTable(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.slotCount != 128 {
+		t.Errorf("%v should equal expected(%v)", h.slotCount, 128)
+	}
+	slot := make([]byte, slotLen)
+	wrapped := 0
+	for i := int64(0); i < 64; i++ {
+		file.ReadAt(slot, headerLen+i*slotLen)
+		if littleEndianKey(slot)&127 == 127 {
+			wrapped++
+		}
+	}
+	if wrapped != 9 {
+		t.Errorf("%v should equal expected(%v)", wrapped, 9)
+	}
+	expected := map[uint64][]uint64{}
+	for i, key := range source.keys {
+		expected[key] = append(expected[key], source.offsets[i])
+	}
+	k := make([]byte, kLen)
+	for key, offsets := range expected {
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+		littleEndianPutKey(k, key)
+		vs, err := h.Gets(k)
+		if err != nil || len(vs) != len(offsets) {
+			t.Fatalf("%v: %v should have %v values, %v", key, vs, offsets, err)
+		}
+		for i, v := range vs {
+			if littleEndianOffset(v) != offsets[i] {
+				t.Errorf("%v: %v should equal expected(%v)", key, littleEndianOffset(v), offsets[i])
+			}
+		}
+	}
+
+	// the probe length of a key is the slots probed to find its first value.
+	probeOf := func(r io.ReaderAt) (probe int) {
+		for key := range expected {
+			n := 1
+			for ; ; n++ {
+				r.ReadAt(slot, headerLen+int64((key+uint64(n)-1)&127)*slotLen)
+				if littleEndianKey(slot) == key {
+					break
+				}
+			}
+			if n > probe {
+				probe = n
+			}
+		}
+		return
+	}
+	if probe := probeOf(file); maxProbe != probe {
+		t.Errorf("%v should equal expected(%v)", maxProbe, probe)
+	}
+	source.rewind()
+	buf := &bytes.Buffer{}
+	maxProbe, err = generate(source, len(source.keys), minVLen, HashFNV1, buf, 0)
+	if probe := probeOf(bytes.NewReader(buf.Bytes())); err != nil || maxProbe != probe {
+		t.Errorf("%v should equal expected(%v), %v", maxProbe, probe, err)
+	}
+}
+
+func TestGenerate_Ascending(t *testing.T) {
+	source := &offsetSource{
+		keys:    []uint64{1, 1, 2, 1, 17, 1},
+		offsets: []uint64{40, 30, 5, 10, 1, 20},
+	}
+	buffer := new(bytes.Buffer)
+	err := Generate(source, len(source.keys), minVLen, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := OpenHashTable(bytes.NewReader(buffer.Bytes()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := make([]byte, kLen)
+	littleEndianPutKey(k, 1)
+	vs, err := h.Gets(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []uint64{10, 20, 30, 40} {
+		if v := littleEndianOffset(vs[i]); v != expected {
+			t.Errorf("%v: %v should equal expected(%v)", i, v, expected)
+		}
+	}
+}
+
+func TestHashTable_ValueLen(t *testing.T) {
+	source := &Source{
+		keys: []int{1, 2, 3},
+	}
+	buffer := new(bytes.Buffer)
+	err := Generate(source, len(source.keys), maxVLen, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := OpenHashTable(bytes.NewReader(buffer.Bytes()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ValueLen() != maxVLen {
+		t.Errorf("%v should equal expected(%v)", h.ValueLen(), maxVLen)
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, 2)
+	v, err := h.Get(b[:kLen])
+	if err != nil || !bytes.Equal(v, b) {
+		t.Errorf("%v should equal expected(%v), %v", v, b, err)
+	}
+	if err := Generate(source, 0, maxVLen+1, buffer); err != ErrInvalidOffsetWidth {
+		t.Error("offset width should be invalid", err)
+	}
+}
+
+func TestOpenHashTable_Corruption(t *testing.T) {
+	source := &Source{
+		keys: []int{1, 2, 3},
+	}
+	buffer := new(bytes.Buffer)
+	err := Generate(source, len(source.keys), minVLen, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	isCorruption := func(err error) bool {
+		var e *CorruptionError
+		return errors.As(err, &e)
+	}
+
+	for name, b := range map[string][]byte{
+		"empty":     {},
+		"truncated": data[:len(data)-1],
+		"header":    data[:headerLen],
+		"foreign":   bytes.Repeat([]byte{0x42}, len(data)),
+		"version": func() []byte {
+			b := append([]byte(nil), data...)
+			b[8] = 2
+			return b
+		}(),
+	} {
+		if _, err := OpenHashTable(bytes.NewReader(b), false); !isCorruption(err) {
+			t.Errorf("%v: should be corrupted, %v", name, err)
+		}
+	}
+
+	damaged := append([]byte(nil), data...)
+	damaged[headerLen+1] ^= 0xff
+	h, err := OpenHashTable(bytes.NewReader(damaged), false)
+	if err != nil {
+		t.Fatal("checksum should not be verified:", err)
+	}
+	if err := h.Verify(); !isCorruption(err) {
+		t.Error("checksum should mismatch:", err)
+	}
+	if _, err := OpenHashTable(bytes.NewReader(damaged), true); !isCorruption(err) {
+		t.Error("checksum should mismatch:", err)
+	}
+}
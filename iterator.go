@@ -0,0 +1,106 @@
+package zyxindex
+
+import "io"
+
+// IteratorOptions holds the optional parameters for an Iterator.
+// A nil *IteratorOptions is valid and means all defaults.
+type IteratorOptions struct {
+	// SkipValues defines whether the values should not be read, then
+	// Iterator.Value returns nil and the values are seeked over.
+	//
+	// The default is false.
+	SkipValues bool
+}
+
+func (o *IteratorOptions) GetSkipValues() bool {
+	if o == nil {
+		return false
+	}
+	return o.SkipValues
+}
+
+// Iterator iterates over the records of the data file in file order,
+// including the records not indexed yet and the records of the same key.
+// The data file is parsed as building the indexes: a partial record at the
+// end of the file ends the iteration without error.
+//
+// usage:
+//
+//	it := db.NewIterator(nil)
+//	for it.Next() {
+//		key, value := it.Key(), it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+//
+// An Iterator is not safe for concurrent use, but it is safe to use multiple
+// iterators concurrently.
+type Iterator struct {
+	d *recordDecoder
+
+	offset int64
+	key    []byte
+	value  []byte
+	err    error
+}
+
+// NewIterator returns an Iterator over the records in the data file, up to
+// its size when NewIterator is called.
+func (db *DB) NewIterator(o *IteratorOptions) *Iterator {
+	info, err := db.file.Stat()
+	if err != nil {
+		return &Iterator{err: err}
+	}
+	return NewFileIterator(db.file, info.Size(), o, db.o)
+}
+
+// NewFileIterator returns an Iterator over the records in the first size
+// bytes of the data file r, which needs no indexes. The records exceeding
+// limits.MaxKeySize or limits.MaxValueSize are corrupt, as in Open.
+func NewFileIterator(r io.ReaderAt, size int64, o *IteratorOptions, limits *Options) *Iterator {
+	return &Iterator{
+		d: newRecordDecoder(r, 0, size, true, o.GetSkipValues(), limits),
+	}
+}
+
+// Next moves the iterator to the next record, it returns false after the
+// last record or on error.
+func (it *Iterator) Next() bool {
+	if it.err != nil || it.d == nil {
+		return false
+	}
+	offset, key, value, err := it.d.next()
+	if err != nil {
+		if err != io.EOF {
+			it.err = err
+		}
+		it.d = nil
+		it.key, it.value = nil, nil
+		return false
+	}
+	it.offset, it.key, it.value = offset, key, value
+	return true
+}
+
+// Key returns the key of the current record.
+// The returned slice is valid until the next call to Next.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value of the current record, or nil if the values are
+// skipped. The returned slice is valid until the next call to Next.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Offset returns the offset of the current record in the data file.
+func (it *Iterator) Offset() int64 {
+	return it.offset
+}
+
+// Err returns the error stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
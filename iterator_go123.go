@@ -0,0 +1,24 @@
+//go:build go1.23
+
+package zyxindex
+
+import "iter"
+
+// All returns an iter.Seq2 over the keys and the values of the remaining
+// records, the error stopped the iteration is returned by Err after.
+// The yielded slices are valid until the next iteration.
+//
+//	for key, value := range it.All() {
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+func (it *Iterator) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for it.Next() {
+			if !yield(it.Key(), it.Value()) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+//go:build go1.23
+
+package zyxindex
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIterator_All(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "a", "1")
+	writeRecord(file, "b", "2")
+	writeRecord(file, "c", "3")
+	file.Close()
+	db, err := Open(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	it := db.NewIterator(nil)
+	var keys, values string
+	for k, v := range it.All() {
+		keys += string(k)
+		values += string(v)
+		if k[0] == 'b' {
+			break
+		}
+	}
+	if keys != "ab" || values != "12" {
+		t.Errorf("%v, %v should equal expected(ab, 12)", keys, values)
+	}
+	for k := range it.All() {
+		keys += string(k)
+	}
+	if keys != "abc" || it.Err() != nil {
+		t.Errorf("%v should equal expected(abc), %v", keys, it.Err())
+	}
+}
@@ -0,0 +1,57 @@
+package zyxindex
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	long := strings.Repeat("k", 2000)
+	large := strings.Repeat("v", recordBufferSize*2)
+	records := [][2]string{{"123", "456"}, {long, "long key"}, {"large", large}, {"123", ""}, {"last", "!"}}
+	for _, r := range records {
+		writeRecord(file, r[0], r[1])
+	}
+	// a record being appended
+	binary.Write(file, binary.LittleEndian, uint64(3))
+	file.Close()
+	db, err := Open(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, err := db.Get([]byte(long)); err != nil || string(v) != "long key" {
+		t.Errorf("%q should equal %q, %v", v, "long key", err)
+	}
+
+	for _, skip := range []bool{false, true} {
+		it := db.NewIterator(&IteratorOptions{SkipValues: skip})
+		i := 0
+		offset := int64(0)
+		for ; it.Next(); i++ {
+			r := records[i]
+			if string(it.Key()) != r[0] || it.Offset() != offset {
+				t.Errorf("%v: %q at %v should equal expected(%q at %v)", i, it.Key(), it.Offset(), r[0], offset)
+			}
+			if skip && it.Value() != nil || !skip && string(it.Value()) != r[1] {
+				t.Errorf("%v: value %.10q, skip %v", i, it.Value(), skip)
+			}
+			offset += int64(16 + len(r[0]) + len(r[1]))
+		}
+		if it.Err() != nil || i != len(records) {
+			t.Errorf("%v should equal expected(%v), %v", i, len(records), it.Err())
+		}
+		if it.Next() {
+			t.Error("iterator should be done")
+		}
+	}
+}
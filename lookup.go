@@ -0,0 +1,146 @@
+package zyxindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sync"
+)
+
+/*
+	the lookup path of DB.Get, DB.GetInto and DB.Gets.
+
+	The hash tables which implement offsetProber are probed slot by slot
+	into the buffers of a pooled lookup, and the key size, the key and the
+	value size of a record are read by one ReadAt, so nothing is allocated
+	but the value. Other HashTablers are looked up by Gets.
+*/
+
+// offsetProber is implemented by the hash tables which probe the slots of a
+// key without allocations.
+type offsetProber interface {
+	// probe finds the first slot matched k from the i-th probe on.
+	// buf is a scratch buffer of kLen+maxVLen bytes.
+	// @return offset, the value of the matched slot.
+	// @return next, the probe to continue from.
+	// @return err, os.ErrNotExist if no more slot matches.
+	probe(k []byte, i uint64, buf []byte) (offset uint64, next uint64, err error)
+}
+
+// recordHeadLen is the length of the key size and the value size of a record.
+const recordHeadLen = 16
+
+// lookup is the buffers of one lookup.
+type lookup struct {
+	key  [kLen]byte
+	slot [kLen + maxVLen]byte
+	// head holds the key size, the key and the value size of a record.
+	head []byte
+}
+
+var lookupPool = sync.Pool{
+	New: func() interface{} {
+		return &lookup{head: make([]byte, 0, recordHeadLen+256)}
+	},
+}
+
+// findRecords calls fn with every record of key in file order, until fn
+// returns false. db.mu must be held.
+// @param fn, called with the offset and the size of the value.
+// @return err, os.ErrNotExist if there is no record of key.
+func (db *DB) findRecords(key []byte, l *lookup, fn func(valueOffset int64, valueSize uint64) bool) (err error) {
+	found := false
+	err = db.probeOffsets(db.hasher.Sum64(key), l, func(offset uint64) (more bool, err error) {
+		valueSize, err := db.readHead(offset, key, l)
+		if err == os.ErrNotExist {
+			// different keys may share a hash.
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		found = true
+		return fn(int64(offset)+recordHeadLen+int64(len(key)), valueSize), nil
+	})
+	if err == nil && !found {
+		err = os.ErrNotExist
+	}
+	return
+}
+
+// probeOffsets calls visit with the offsets of all keys hashed to hash64 in
+// file order, until visit returns false or an error. db.mu must be held.
+func (db *DB) probeOffsets(hash64 uint64, l *lookup, visit func(offset uint64) (more bool, err error)) (err error) {
+	littleEndianPutKey(l.key[:], hash64)
+	// the records in delta are after the records in shards.
+	for shards := &db.shards; shards != nil; shards = shards.delta {
+		table := shards.tables[shardIdOf(hash64, shards.shardBits)]
+		p, ok := table.(offsetProber)
+		if !ok {
+			vs, e := table.Gets(l.key[:])
+			if e == os.ErrNotExist {
+				continue
+			}
+			if e != nil {
+				return e
+			}
+			for _, v := range vs {
+				more, e := visit(littleEndianOffset(v))
+				if !more {
+					return e
+				}
+			}
+			continue
+		}
+		for i := uint64(0); ; {
+			var offset uint64
+			offset, i, err = p.probe(l.key[:], i, l.slot[:])
+			if err == os.ErrNotExist {
+				break
+			}
+			if err != nil {
+				return
+			}
+			more, e := visit(offset)
+			if !more {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// readHead reads the key size, the key and the value size of the record at
+// offset by one ReadAt.
+// @return err, os.ErrNotExist if the record's key is not key, *RecordError
+// if the value size exceeds the max.
+func (db *DB) readHead(offset uint64, key []byte, l *lookup) (valueSize uint64, err error) {
+	if uint64(len(key)) > db.o.GetMaxKeySize() {
+		// no record has the key.
+		return 0, os.ErrNotExist
+	}
+	n := recordHeadLen + len(key)
+	if cap(l.head) < n {
+		l.head = make([]byte, n)
+	}
+	b := l.head[:n]
+	m, err := db.file.ReadAt(b, int64(offset))
+	if m < 8 {
+		return
+	}
+	if binary.LittleEndian.Uint64(b) != uint64(len(key)) {
+		// the record may be shorter than b.
+		return 0, os.ErrNotExist
+	}
+	if m < n {
+		return
+	}
+	if !bytes.Equal(b[8:8+len(key)], key) {
+		return 0, os.ErrNotExist
+	}
+	valueSize = binary.LittleEndian.Uint64(b[8+len(key):])
+	if max := db.o.GetMaxValueSize(); valueSize > max {
+		return 0, newRecordError(int64(offset), "value size %d exceeds %d", valueSize, max)
+	}
+	return
+}
@@ -1,27 +1,89 @@
 package zyxindex
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 /*
 a manifest is a json file, which descriptes the indexes of database;
 it looks like below:
 {
-	"version": 1,
-	"shard_num": 256
+	"version": 7,
+	"generation": 5,
+	"shard_num": 256,
+	"offset_width": 5,
+	"hash": 3,
+	"hash_seed": 12478082351384186161,
+	"max_probe_length": 12,
+	"data_size": 1048576,
+	"data_mtime": 1548892800000000000,
+	"data_fingerprint": "9d3a6ac4c2e5f3a1",
+	"base_size": 1000000,
+	"base_generation": 3,
+	"delta_generation": 5,
+	"delta_entries": 42
 }
+the data_* fields describe the data file when the indexes are built or
+refreshed, data_size is the indexed byte length. So the indexes of a replaced
+or modified data file are detected as stale, and the appended records are
+detected for refreshing.
+the base shards in generation base_generation index the records before
+base_size, the delta index in generation delta_generation indexes the rest
+if delta_entries is not 0.
+hash is the HashFunc of the keys and hash_seed is its seed, the indexes of
+version 6 have neither and are hashed by FNV-1. max_probe_length is the most
+slots probed to find a key in the base shards.
+the manifest is in its own generation, which is named by the CURRENT file.
 */
 
-const version = 1
+const version = 7
+
+// minVersion is the oldest version of the manifests which can be read.
+const minVersion = 6
 
 type Manifest struct {
-	Version  int `json:"version"`
-	ShardNum int `json:"shard_num"`
+	Version     int `json:"version"`
+	Generation  int `json:"generation"`
+	ShardNum    int `json:"shard_num"`
+	OffsetWidth int `json:"offset_width"`
+
+	Hash     HashFunc `json:"hash,omitempty"`
+	HashSeed uint64   `json:"hash_seed,omitempty"`
+
+	MaxProbeLength int `json:"max_probe_length,omitempty"`
+
+	DataSize        int64  `json:"data_size"`
+	DataModTime     int64  `json:"data_mtime"`
+	DataFingerprint string `json:"data_fingerprint"`
+
+	BaseSize        int64 `json:"base_size"`
+	BaseGeneration  int   `json:"base_generation"`
+	DeltaGeneration int   `json:"delta_generation"`
+	DeltaEntries    int   `json:"delta_entries"`
+}
+
+// hashFunc returns the hash function of the indexes.
+func (m *Manifest) hashFunc() HashFunc {
+	if m.Hash == 0 {
+		return HashFNV1
+	}
+	return m.Hash
+}
+
+// hasher returns the Hasher of the indexes.
+// @return err, ErrUnknownHash if the hash function is not built in.
+func (m *Manifest) hasher() (Hasher, error) {
+	return NewHasher(m.hashFunc(), m.HashSeed)
 }
 
+// ManifestPath returns the path of the manifest in a generation directory.
 func ManifestPath(dir string) string {
 	return filepath.Join(dir, "manifest")
 }
@@ -31,19 +93,100 @@ func CreateManifestFile(dir string, manifest *Manifest) (err error) {
 	if err != nil {
 		return
 	}
+	defer file.Close()
 	enc := json.NewEncoder(file)
 	err = enc.Encode(manifest)
-	return
+	if err != nil {
+		return
+	}
+	return file.Sync()
 }
 
+// loadManifest loads the manifest of the current generation in dir.
+// @return err, os.IsNotExist if there is no index in dir, ErrCorruptIndex if
+// the manifest is missing or damaged.
 func loadManifest(dir string) (manifest *Manifest, err error) {
+	generation, err := readCurrent(dir)
+	if err != nil {
+		return
+	}
 	manifest = new(Manifest)
-	manifestPath := ManifestPath(dir)
+	manifestPath := ManifestPath(GenerationPath(dir, generation))
 	file, err := os.Open(manifestPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s is missing", ErrCorruptIndex, manifestPath)
+	}
 	if err != nil {
 		return
 	}
+	defer file.Close()
 	dec := json.NewDecoder(file)
 	err = dec.Decode(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorruptIndex, manifestPath, err)
+	}
 	return
 }
+
+const (
+	fingerprintSamples   = 16
+	fingerprintBlockSize = 4 << 10
+)
+
+// fingerprint hashes sampled blocks of the first size bytes of r,
+// the first and the last blocks are always sampled.
+func fingerprint(r io.ReaderAt, size int64) (string, error) {
+	hash := fnv.New64a()
+	block := make([]byte, fingerprintBlockSize)
+	if size < fingerprintBlockSize {
+		block = block[:size]
+	}
+	var last int64 = -1
+	for i := int64(0); i < fingerprintSamples; i++ {
+		off := (size - int64(len(block))) * i / (fingerprintSamples - 1)
+		if off == last {
+			continue
+		}
+		last = off
+		_, err := r.ReadAt(block, off)
+		if err != nil {
+			return "", err
+		}
+		hash.Write(block)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// setData records the data file into the manifest.
+// @param size, the indexed byte length of file.
+func (m *Manifest) setData(file *os.File, size int64, info os.FileInfo) (err error) {
+	m.DataSize = size
+	m.DataModTime = info.ModTime().UnixNano()
+	m.DataFingerprint, err = fingerprint(file, size)
+	return
+}
+
+// checkData compares the data file with the manifest.
+// @return appended, true if records may be appended after the indexed bytes.
+// @return reason, why the indexes are stale, or empty if not.
+func (m *Manifest) checkData(file *os.File) (appended bool, reason string, err error) {
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	if info.Size() < m.DataSize {
+		return false, fmt.Sprintf("data size %d, indexed %d", info.Size(), m.DataSize), nil
+	}
+	if info.Size() == m.DataSize && info.ModTime().UnixNano() != m.DataModTime {
+		return false, fmt.Sprintf("data modified at %v, indexed %v",
+			info.ModTime(), time.Unix(0, m.DataModTime)), nil
+	}
+	fp, err := fingerprint(file, m.DataSize)
+	if err != nil {
+		return
+	}
+	if fp != m.DataFingerprint {
+		return false, fmt.Sprintf("data fingerprint %s, indexed %s", fp, m.DataFingerprint), nil
+	}
+	return info.Size() > m.DataSize, "", nil
+}
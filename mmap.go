@@ -0,0 +1,269 @@
+package zyxindex
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+/*
+	MmapHashTable is a HashTabler on the memory mapped hash table file,
+	it probes the slots in memory without syscalls and allocations.
+	The memory is mapped by mmapFile of the platform, errMmapUnsupported
+	means opening the file as a HashTable instead.
+*/
+
+// MmapAdvice is the madvise hint of the mapped hash tables.
+type MmapAdvice int
+
+const (
+	// MmapRandom expects the slots are read in random order, it is the default.
+	MmapRandom MmapAdvice = iota
+	// MmapWillNeed expects the slots are read soon, so reads them ahead.
+	MmapWillNeed
+	// MmapNormal has no hint.
+	MmapNormal
+)
+
+var errMmapUnsupported = errors.New("zyxindex: mmap is not supported")
+
+type MmapHashTable struct {
+	// the whole mapped file
+	data []byte
+	// the slots in data
+	slots []byte
+
+	slotCount  uint64
+	entryCount uint64
+	slotLen    uint64
+	vLen       int
+	hash       HashFunc
+	checksum   uint32
+
+	// notExistSlot of vLen
+	notExistSlot []byte
+}
+
+// OpenMmapHashTable maps a hash table from a file, f can be closed after.
+// The header and the footer are always checked, the checksum of slots is
+// checked only if verify is true.
+// @return err, *CorruptionError if the file is truncated, foreign or damaged.
+func OpenMmapHashTable(f *os.File, verify bool, advice MmapAdvice) (h *MmapHashTable, err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if info.Size() < headerLen+footerLen {
+		return nil, newCorruptionError(f, "truncated header")
+	}
+	data, err := mmapFile(f, int(info.Size()))
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			munmap(data)
+		}
+	}()
+
+	th, reason := decodeTableHeader(data[:headerLen])
+	if reason != "" {
+		return nil, newCorruptionError(f, "%s", reason)
+	}
+	slotsLen := th.slotCount * uint64(th.slotLen)
+	if uint64(len(data)) < headerLen+slotsLen+footerLen {
+		return nil, newCorruptionError(f, "truncated slots")
+	}
+	footer := data[headerLen+slotsLen : headerLen+slotsLen+footerLen]
+	if string(footer[4:]) != footerMagic {
+		return nil, newCorruptionError(f, "bad footer magic")
+	}
+	err = madvise(data, advice)
+	if err != nil {
+		return
+	}
+
+	h = &MmapHashTable{
+		data:         data,
+		slots:        data[headerLen : headerLen+slotsLen],
+		slotCount:    th.slotCount,
+		entryCount:   th.entryCount,
+		slotLen:      uint64(th.slotLen),
+		vLen:         th.vLen,
+		hash:         th.hashID,
+		checksum:     littleEndianUint32(footer),
+		notExistSlot: notExistSlot(th.vLen),
+	}
+	if verify && crc32.Checksum(h.slots, castagnoli) != h.checksum {
+		return nil, newCorruptionError(f, "checksum mismatch")
+	}
+	return
+}
+
+func littleEndianUint32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// ValueLen returns the value width of the hash table.
+func (h *MmapHashTable) ValueLen() int {
+	return h.vLen
+}
+
+// HashFunc returns the hash function of the keys in the hash table.
+func (h *MmapHashTable) HashFunc() HashFunc {
+	return h.hash
+}
+
+// SlotCount returns the slots of the hash table.
+func (h *MmapHashTable) SlotCount() uint64 {
+	return h.slotCount
+}
+
+// EntryCount returns the entries of the hash table.
+func (h *MmapHashTable) EntryCount() uint64 {
+	return h.entryCount
+}
+
+func (h *MmapHashTable) slot(i uint64) []byte {
+	return h.slots[i*h.slotLen : (i+1)*h.slotLen]
+}
+
+// Get gets value of the key from hash table
+// The returned value is in the mapped memory, which is valid until Close.
+// @param k, the key
+// @return v, the value
+// @return err, nil when the key exists, os.ErrNotExist when the key miss.
+func (h *MmapHashTable) Get(k []byte) (v []byte, err error) {
+	slot := littleEndianKey(k) & (h.slotCount - 1)
+	for i := uint64(0); i < h.slotCount; i++ {
+		b := h.slot(slot)
+		if bytes.Equal(b, h.notExistSlot) {
+			return nil, os.ErrNotExist
+		}
+		if bytes.Equal(b[:kLen], k) {
+			return b[kLen:], nil
+		}
+		slot = nextSlot(slot, h.slotCount)
+	}
+	return nil, os.ErrNotExist
+}
+
+// probe implements offsetProber, buf is not used.
+func (h *MmapHashTable) probe(k []byte, i uint64, buf []byte) (offset uint64, next uint64, err error) {
+	slot := (littleEndianKey(k) + i) & (h.slotCount - 1)
+	for ; i < h.slotCount; i++ {
+		b := h.slot(slot)
+		if bytes.Equal(b, h.notExistSlot) {
+			return 0, i, os.ErrNotExist
+		}
+		if bytes.Equal(b[:kLen], k) {
+			return littleEndianOffset(b[kLen:]), i + 1, nil
+		}
+		slot = nextSlot(slot, h.slotCount)
+	}
+	return 0, i, os.ErrNotExist
+}
+
+// Gets gets all values of the key from hash table, in ascending order.
+// The returned values are in the mapped memory, which are valid until Close.
+func (h *MmapHashTable) Gets(k []byte) (vs [][]byte, err error) {
+	slot := littleEndianKey(k) & (h.slotCount - 1)
+	for i := uint64(0); i < h.slotCount; i++ {
+		b := h.slot(slot)
+		if bytes.Equal(b, h.notExistSlot) {
+			break
+		}
+		if bytes.Equal(b[:kLen], k) {
+			vs = append(vs, b[kLen:])
+		}
+		slot = nextSlot(slot, h.slotCount)
+	}
+	if len(vs) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return
+}
+
+// Range calls fn with the key and the value of every non-empty slot, in slot
+// order. Range stops and returns the error if fn returns an error.
+func (h *MmapHashTable) Range(fn func(k, v []byte) error) (err error) {
+	return h.RangeSlots(func(slot uint64, k, v []byte) error {
+		return fn(k, v)
+	})
+}
+
+// RangeSlots is Range with the slot index of every k and v.
+func (h *MmapHashTable) RangeSlots(fn func(slot uint64, k, v []byte) error) (err error) {
+	for i := uint64(0); i < h.slotCount; i++ {
+		b := h.slot(i)
+		if bytes.Equal(b, h.notExistSlot) {
+			continue
+		}
+		err = fn(i, b[:kLen], b[kLen:])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Verify checks the slots against the checksum in the footer.
+// @return err, *CorruptionError if the checksum mismatches.
+func (h *MmapHashTable) Verify() error {
+	if crc32.Checksum(h.slots, castagnoli) != h.checksum {
+		return &CorruptionError{Reason: "checksum mismatch"}
+	}
+	return nil
+}
+
+// Close unmaps the hash table, the values got are invalid after.
+func (h *MmapHashTable) Close() error {
+	if h.data == nil {
+		return nil
+	}
+	data := h.data
+	h.data, h.slots = nil, nil
+	return munmap(data)
+}
+
+// openHashTable opens the hash table file f, by mmap if o.Mmap is true.
+// f is closed by the returned hash table or on error.
+// @param vLen, the value width expected.
+// @param hash, the hash function expected.
+func openHashTable(f *os.File, vLen int, hash HashFunc, verify bool, o *Options) (h HashTabler, err error) {
+	var width int
+	var tableHash HashFunc
+	if o.GetMmap() {
+		table, e := OpenMmapHashTable(f, verify, o.GetMmapAdvice())
+		if e == nil {
+			f.Close()
+			h, width, tableHash = table, table.ValueLen(), table.HashFunc()
+		} else if _, ok := e.(*CorruptionError); ok {
+			f.Close()
+			return nil, e
+		} else if e != errMmapUnsupported {
+			o.GetLogger().Printf("mmap %s failed, read it instead: %v", f.Name(), e)
+		}
+	}
+	if h == nil {
+		table, e := OpenHashTable(f, verify)
+		if e != nil {
+			f.Close()
+			return nil, e
+		}
+		h, width, tableHash = table, table.ValueLen(), table.HashFunc()
+	}
+	if width != vLen {
+		h.Close()
+		return nil, fmt.Errorf("%w: %s has %d bytes offsets, expected %d",
+			ErrInvalidOffsetWidth, f.Name(), width, vLen)
+	}
+	if tableHash != hash {
+		h.Close()
+		return nil, fmt.Errorf("%w: %s is hashed by %v, expected %v",
+			ErrHashMismatch, f.Name(), tableHash, hash)
+	}
+	return
+}
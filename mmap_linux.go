@@ -0,0 +1,26 @@
+//go:build linux
+
+package zyxindex
+
+import (
+	"os"
+	"syscall"
+)
+
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
+
+func madvise(b []byte, advice MmapAdvice) error {
+	switch advice {
+	case MmapRandom:
+		return syscall.Madvise(b, syscall.MADV_RANDOM)
+	case MmapWillNeed:
+		return syscall.Madvise(b, syscall.MADV_WILLNEED)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package zyxindex
+
+import "os"
+
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap(b []byte) error {
+	return nil
+}
+
+func madvise(b []byte, advice MmapAdvice) error {
+	return nil
+}
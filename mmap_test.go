@@ -0,0 +1,164 @@
+package zyxindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+func generateFile(t testing.TB, path string, keys []int) *os.File {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal("create failed:", err)
+	}
+	err = Generate(&Source{keys: keys}, len(keys), minVLen, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func openMmap(t testing.TB, file *os.File, verify bool) *MmapHashTable {
+	h, err := OpenMmapHashTable(file, verify, MmapRandom)
+	if err == errMmapUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestMmapHashTable(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	keys := []int{0, 1, 2, 3, 4, 5, 6, 33, 31, 63, 1}
+	file := generateFile(t, testDir+"/table", keys)
+	h := openMmap(t, file, true)
+	file.Close()
+	defer h.Close()
+
+	b := make([]byte, 8)
+	for _, key := range keys {
+		binary.LittleEndian.PutUint64(b, uint64(key))
+		v, err := h.Get(b[:kLen])
+		if err != nil || !bytes.Equal(v, b[:minVLen]) {
+			t.Errorf("%v: %v should equal expected(%v), %v", key, v, b[:minVLen], err)
+		}
+	}
+	binary.LittleEndian.PutUint64(b, 1)
+	if vs, err := h.Gets(b[:kLen]); err != nil || len(vs) != 2 {
+		t.Errorf("%v should have 2 values, %v", vs, err)
+	}
+	binary.LittleEndian.PutUint64(b, 7)
+	if _, err := h.Get(b[:kLen]); err != os.ErrNotExist {
+		t.Errorf("7: data shoule not exist, %v", err)
+	}
+	n := 0
+	h.Range(func(k, v []byte) error {
+		n++
+		return nil
+	})
+	if n != len(keys) {
+		t.Errorf("%v should equal expected(%v)", n, len(keys))
+	}
+	if err := h.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	binary.LittleEndian.PutUint64(b, 2)
+	allocs := testing.AllocsPerRun(100, func() {
+		h.Get(b[:kLen])
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocs should be 0", allocs)
+	}
+}
+
+func TestMmapHashTable_Corruption(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	file := generateFile(t, testDir+"/table", []int{1, 2, 3})
+	defer file.Close()
+	openMmap(t, file, true).Close()
+
+	file.WriteAt([]byte{0xff}, headerLen+1)
+	h := openMmap(t, file, false)
+	var e *CorruptionError
+	if err := h.Verify(); !errors.As(err, &e) {
+		t.Error("checksum should mismatch:", err)
+	}
+	h.Close()
+	if _, err := OpenMmapHashTable(file, true, MmapRandom); !errors.As(err, &e) {
+		t.Error("checksum should mismatch:", err)
+	}
+	info, _ := file.Stat()
+	file.Truncate(info.Size() - 1)
+	if _, err := OpenMmapHashTable(file, false, MmapRandom); !errors.As(err, &e) {
+		t.Error("should be truncated:", err)
+	}
+}
+
+func TestOpenWithOptions_Mmap(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "123", "456")
+	writeRecord(file, "helloworld", "!")
+	file.Close()
+	for _, o := range []*Options{
+		{Mmap: true, MmapAdvice: MmapWillNeed},
+		{Mmap: true, VerifyChecksums: true},
+	} {
+		db, err := OpenWithOptions(dataPath, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := db.Get([]byte("helloworld"))
+		if err != nil || string(value) != "!" {
+			t.Errorf("%q should equal %q, %v", value, "!", err)
+		}
+		db.Close()
+	}
+}
+
+func benchmarkHashTableGet(b *testing.B, h HashTabler, n int) {
+	k := make([]byte, 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.LittleEndian.PutUint64(k, uint64(i%n))
+		if _, err := h.Get(k[:kLen]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHashTable_Get(b *testing.B) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	keys := make([]int, 1<<16)
+	for i := range keys {
+		keys[i] = i
+	}
+	file := generateFile(b, testDir+"/table", keys)
+	defer file.Close()
+	b.Run("ReaderAt", func(b *testing.B) {
+		h, err := OpenHashTable(file, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		benchmarkHashTableGet(b, h, len(keys))
+	})
+	b.Run("Mmap", func(b *testing.B) {
+		h := openMmap(b, file, false)
+		defer h.Close()
+		benchmarkHashTableGet(b, h, len(keys))
+	})
+}
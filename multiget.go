@@ -0,0 +1,258 @@
+package zyxindex
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+	MultiGet looks up a batch of keys in three steps, each fanned out over
+	at most Options.MultiGetWorkers goroutines:
+
+	1. the keys are grouped by shard, and the hash table of every group is
+	   probed for the candidate offsets of its keys;
+	2. the heads of the first candidate records of the keys are read in
+	   ascending offset order, the keys sharing hashes with other keys are
+	   looked up as Get;
+	3. the values are read in ascending offset order, the values close to
+	   each other are read by one ReadAt.
+
+	MultiGetLimit checks the total size of the values found in 2 before 3.
+*/
+
+const (
+	// multiGetChunk is the candidate records checked by one job.
+	multiGetChunk = 64
+	// multiGetMaxGap is the max bytes between two values read by one ReadAt.
+	multiGetMaxGap = 4 << 10
+	// multiGetMaxRead is the max bytes of one coalesced ReadAt.
+	multiGetMaxRead = 1 << 20
+)
+
+// candidate is a record which may be of keys[key].
+type candidate struct {
+	offset uint64
+	key    int
+
+	valueSize uint64
+	err       error
+}
+
+// valueRead is the value to read for keys[key].
+type valueRead struct {
+	offset int64
+	size   uint64
+	key    int
+}
+
+// MultiGet gets the values for the given keys, like calling Get for every
+// key, but reads the hash tables and the data file in order.
+// values[i] and errs[i] are the value and the error of keys[i], errs[i] is
+// os.ErrNotExist if keys[i] is not found, ErrValueTooLarge if the value is
+// too large to read into memory as Get.
+//
+// The returned slices do not overlap, it is safe to modify their contents.
+func (db *DB) MultiGet(keys [][]byte) (values [][]byte, errs []error) {
+	values, errs, _ = db.multiGet(keys, math.MaxUint64)
+	return
+}
+
+// MultiGetLimit is MultiGet, but reads no value if the total size of the
+// values found exceeds maxBytes, so the memory of a batch is bounded.
+// A key given more than once is counted every time.
+//
+// @return err, ErrValueTooLarge if the values exceed maxBytes, values and
+// errs are nil then.
+func (db *DB) MultiGetLimit(keys [][]byte, maxBytes int64) (values [][]byte, errs []error, err error) {
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	return db.multiGet(keys, uint64(maxBytes))
+}
+
+// multiGet is MultiGet, it reads the values only if they are at most
+// maxBytes in total.
+func (db *DB) multiGet(keys [][]byte, maxBytes uint64) (values [][]byte, errs []error, err error) {
+	values = make([][]byte, len(keys))
+	errs = make([]error, len(keys))
+	workers := db.o.GetMultiGetWorkers()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// 1. probe the hash tables by shard.
+	hashes := make([]uint64, len(keys))
+	groups := make(map[int][]int)
+	for i, key := range keys {
+		hashes[i] = db.hasher.Sum64(key)
+		shardId := shardIdOf(hashes[i], db.shards.shardBits)
+		groups[shardId] = append(groups[shardId], i)
+	}
+	shardIds := make([]int, 0, len(groups))
+	for shardId := range groups {
+		shardIds = append(shardIds, shardId)
+	}
+	sort.Ints(shardIds)
+	var candidates []candidate
+	var mu sync.Mutex
+	parallel(len(shardIds), workers, func(job int) {
+		l := lookupPool.Get().(*lookup)
+		defer lookupPool.Put(l)
+		group := groups[shardIds[job]]
+		first := make([]candidate, 0, len(group))
+		for _, i := range group {
+			errs[i] = db.probeOffsets(hashes[i], l, func(offset uint64) (bool, error) {
+				first = append(first, candidate{offset: offset, key: i})
+				return false, nil
+			})
+		}
+		mu.Lock()
+		candidates = append(candidates, first...)
+		mu.Unlock()
+	})
+
+	// 2. check the heads of the candidate records by offset.
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].offset < candidates[b].offset
+	})
+	parallel((len(candidates)+multiGetChunk-1)/multiGetChunk, workers, func(job int) {
+		l := lookupPool.Get().(*lookup)
+		defer lookupPool.Put(l)
+		end := (job + 1) * multiGetChunk
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+		for j := job * multiGetChunk; j < end; j++ {
+			c := &candidates[j]
+			c.valueSize, c.err = db.readHead(c.offset, keys[c.key], l)
+		}
+	})
+	var reads []valueRead
+	var others []int
+	found := make([]bool, len(keys))
+	for _, c := range candidates {
+		if c.err == nil {
+			c.err = db.checkReadSize(c.valueSize)
+		}
+		switch c.err {
+		case nil:
+			found[c.key] = true
+			reads = append(reads, valueRead{
+				offset: int64(c.offset) + recordHeadLen + int64(len(keys[c.key])),
+				size:   c.valueSize,
+				key:    c.key,
+			})
+		case os.ErrNotExist:
+			// the first offset is of another key with the same hash.
+			others = append(others, c.key)
+		default:
+			errs[c.key] = c.err
+		}
+	}
+	// the rare keys sharing hashes with other keys are looked up one by one,
+	// the first record of a key in file order wins, as Get.
+	parallel(len(others), workers, func(job int) {
+		l := lookupPool.Get().(*lookup)
+		defer lookupPool.Put(l)
+		i := others[job]
+		var r valueRead
+		errs[i] = db.findRecords(keys[i], l, func(valueOffset int64, valueSize uint64) bool {
+			r = valueRead{offset: valueOffset, size: valueSize, key: i}
+			return false
+		})
+		if errs[i] == nil {
+			errs[i] = db.checkReadSize(r.size)
+		}
+		if errs[i] == nil {
+			mu.Lock()
+			reads = append(reads, r)
+			mu.Unlock()
+			found[i] = true
+		}
+	})
+	for i := range keys {
+		if !found[i] && errs[i] == nil {
+			errs[i] = os.ErrNotExist
+		}
+	}
+	if len(others) > 0 {
+		sort.Slice(reads, func(a, b int) bool {
+			return reads[a].offset < reads[b].offset
+		})
+	}
+	var total uint64
+	for _, r := range reads {
+		if r.size > maxBytes-total {
+			return nil, nil, fmt.Errorf("%w: values exceed %d bytes", ErrValueTooLarge, maxBytes)
+		}
+		total += r.size
+	}
+
+	// 3. read the values in offset order.
+	var batches [][]valueRead
+	for start := 0; start < len(reads); {
+		end := start + 1
+		readEnd := reads[start].offset + int64(reads[start].size)
+		for ; end < len(reads); end++ {
+			r := reads[end]
+			if r.offset-readEnd > multiGetMaxGap || r.offset+int64(r.size)-reads[start].offset > multiGetMaxRead {
+				break
+			}
+			readEnd = r.offset + int64(r.size)
+		}
+		batches = append(batches, reads[start:end])
+		start = end
+	}
+	parallel(len(batches), workers, func(job int) {
+		batch := batches[job]
+		start := batch[0].offset
+		last := batch[len(batch)-1]
+		buf := make([]byte, last.offset+int64(last.size)-start)
+		_, err := db.file.ReadAt(buf, start)
+		var covered int64
+		for _, r := range batch {
+			if err != nil {
+				errs[r.key] = err
+				continue
+			}
+			from := r.offset - start
+			to := from + int64(r.size)
+			if from < covered {
+				// a key given more than once.
+				values[r.key] = append([]byte{}, buf[from:to]...)
+				continue
+			}
+			values[r.key] = buf[from:to:to]
+			covered = to
+		}
+	})
+	return
+}
+
+// parallel calls fn with 0 to n-1 on at most workers goroutines.
+func parallel(n, workers int, fn func(job int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for job := 0; job < n; job++ {
+			fn(job)
+		}
+		return
+	}
+	var next int64 = -1
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for job := int(atomic.AddInt64(&next, 1)); job < n; job = int(atomic.AddInt64(&next, 1)) {
+				fn(job)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,106 @@
+package zyxindex
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDB_MultiGet(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	for i := 0; i < 1000; i++ {
+		writeRecord(file, fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	writeRecord(file, "key1", "again")
+	writeRecord(file, "empty", "")
+	db, err := OpenWithOptions(dataPath, &Options{ShardBits: 4, Logger: &testLogger{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	writeRecord(file, "appended", "delta")
+	file.Close()
+	if err := db.Refresh(); err != nil {
+		t.Fatal("refresh failed", err)
+	}
+
+	var keys [][]byte
+	for i := 999; i >= 0; i -= 3 {
+		keys = append(keys, []byte(fmt.Sprintf("key%d", i)))
+	}
+	keys = append(keys, []byte("key1"), []byte("missing"), []byte("empty"), []byte("appended"), []byte("key1"))
+	for _, workers := range []int{1, 4} {
+		db.o = &Options{MultiGetWorkers: workers}
+		values, errs := db.MultiGet(keys)
+		if len(values) != len(keys) || len(errs) != len(keys) {
+			t.Fatalf("%v, %v should have %v values", len(values), len(errs), len(keys))
+		}
+		for i, key := range keys {
+			value, err := db.Get(key)
+			if errs[i] != err || string(values[i]) != string(value) {
+				t.Errorf("%s: %q, %v should equal expected(%q, %v)", key, values[i], errs[i], value, err)
+			}
+		}
+		values[len(keys)-1][0] = 'X'
+		if string(values[len(keys)-5]) != "value1" {
+			t.Errorf("%q should equal %q", values[len(keys)-5], "value1")
+		}
+	}
+	if values, errs := db.MultiGet(nil); len(values) != 0 || len(errs) != 0 {
+		t.Errorf("%v, %v should be empty", values, errs)
+	}
+
+	// key1 is given twice, its value is counted twice.
+	keys = [][]byte{[]byte("key1"), []byte("missing"), []byte("appended"), []byte("key1")}
+	values, errs, err := db.MultiGetLimit(keys, 17)
+	if err != nil || string(values[0]) != "value1" || errs[1] != os.ErrNotExist || string(values[2]) != "delta" || string(values[3]) != "value1" {
+		t.Errorf("%q, %v, %v should have the values", values, errs, err)
+	}
+	values, errs, err = db.MultiGetLimit(keys, 16)
+	if !errors.Is(err, ErrValueTooLarge) || values != nil || errs != nil {
+		t.Errorf("%v, %v, %v should equal expected(%v)", values, errs, err, ErrValueTooLarge)
+	}
+}
+
+func BenchmarkDB_MultiGet(b *testing.B) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		b.Fatal("create file failed", err)
+	}
+	const n = 1 << 14
+	for i := 0; i < n; i++ {
+		writeRecord(file, fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	file.Close()
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%d", i*n/len(keys)))
+	}
+	db, err := OpenWithOptions(dataPath, &Options{Logger: &testLogger{}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	b.Run("Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				db.Get(key)
+			}
+		}
+	})
+	b.Run("MultiGet", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			db.MultiGet(keys)
+		}
+	})
+}
@@ -0,0 +1,430 @@
+package zyxindex
+
+import (
+	"log"
+	"math"
+	"runtime"
+	"time"
+)
+
+// IndexDirSuffix is the suffix of the default index directory of a data file.
+const IndexDirSuffix = ".idx"
+
+// DefaultMaxDeltaEntries is the default of Options.MaxDeltaEntries.
+const DefaultMaxDeltaEntries = 1 << 20
+
+// DefaultBuildMemory is the default of Options.BuildMemory.
+const DefaultBuildMemory = 1 << 30
+
+// DefaultMaxKeySize is the default of Options.MaxKeySize.
+const DefaultMaxKeySize = 1 << 20
+
+// DefaultMaxReadSize is the max value size read into memory by Get, GetInto,
+// Gets and MultiGet if Options.MaxValueSize is not set, 64MB.
+const DefaultMaxReadSize = 64 << 20
+
+// Logger is the interface the DB writes its log messages to.
+// *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// StalePolicy defines what to do with indexes built for an older version
+// of the data file.
+type StalePolicy int
+
+const (
+	// StaleRebuild rebuilds the stale indexes, or returns ErrStaleIndex in
+	// read-only mode.
+	StaleRebuild StalePolicy = iota
+	// StaleError returns ErrStaleIndex.
+	StaleError
+	// StaleIgnore uses the stale indexes as they are.
+	StaleIgnore
+)
+
+// Options holds the optional parameters for the DB.
+// A nil *Options is valid and means all defaults.
+type Options struct {
+	// IndexDir is the directory of the manifest and the hash tables. The
+	// generations and tmp files of the indexes not in use are removed from
+	// it, the other files are kept.
+	//
+	// The default is the path of the data file with the suffix IndexDirSuffix.
+	IndexDir string
+
+	// ReadOnly opens the DB without writing anything into IndexDir.
+	// Open returns an error instead of building the indexes if they are missing.
+	//
+	// The default is false.
+	ReadOnly bool
+
+	// ErrorIfMissing defines whether an error should returned if the indexes
+	// are missing. If false then the indexes will be built.
+	//
+	// The default is false.
+	ErrorIfMissing bool
+
+	// ErrorIfExist defines whether an error should returned if the indexes
+	// already exist.
+	//
+	// The default is false.
+	ErrorIfExist bool
+
+	// Rebuild defines whether the indexes should be built even if they exist.
+	//
+	// The default is false.
+	Rebuild bool
+
+	// RebuildIfCorrupt defines whether the indexes should be rebuilt if they
+	// are unusable: missing hash tables, an unsupported version, damaged
+	// files, or files which do not match the manifest. If false then Open
+	// returns the error. It is ignored in read-only mode.
+	//
+	// The default is false.
+	RebuildIfCorrupt bool
+
+	// StalePolicy defines what to do when the size, modification time or
+	// fingerprint of the data file differs from the manifest.
+	//
+	// The default is StaleRebuild.
+	StalePolicy StalePolicy
+
+	// MaxDeltaEntries defines the max entries of the delta index built by
+	// Refresh, the delta is merged into the base shards when exceeded.
+	// Use -1 to never merge automatically.
+	//
+	// The default is DefaultMaxDeltaEntries.
+	MaxDeltaEntries int
+
+	// ShardBits defines the shard count of the indexes built, 1<<ShardBits
+	// shards. It must not exceed MaxShardBits. Use -1 for a single shard.
+	// The indexes opened use the shard count of their manifest.
+	//
+	// The default is DefaultShardBits.
+	ShardBits int
+
+	// OffsetWidth defines the bytes of offsets in the indexes built,
+	// from 5 (1TB data file) to 8. Building fails with ErrOffsetOverflow
+	// if the data file is too large for it.
+	//
+	// The default is the min width for the size of the data file.
+	OffsetWidth int
+
+	// MaxKeySize defines the max key size of the records in bytes, the records
+	// of larger key sizes are corrupt, which fails building the indexes with
+	// ErrCorruptRecord. The keys larger than it are not found by Get.
+	//
+	// The default is DefaultMaxKeySize.
+	MaxKeySize int64
+
+	// MaxValueSize defines the max value size of the records in bytes, the
+	// records of larger value sizes are corrupt, which fails building the
+	// indexes and the lookups with ErrCorruptRecord. Get, GetInto, Gets and
+	// MultiGet read the values into memory up to it, or up to
+	// DefaultMaxReadSize if it is not set, the larger values get
+	// ErrValueTooLarge and are read by OpenValue.
+	//
+	// The default is no limit, the value sizes are limited by the size of
+	// the data file.
+	MaxValueSize int64
+
+	// Hash defines the hash function of the keys in the indexes built.
+	// The indexes opened use the hash function of their manifest.
+	//
+	// The default is DefaultHash.
+	Hash HashFunc
+
+	// HashSeed defines the seed of the hash function in the indexes built,
+	// every seed including 0 is used as it is, FNV with seed 0 is the
+	// standard unseeded FNV. It is a pointer so that nil is distinguished
+	// from seed 0.
+	//
+	// The default is nil, a random seed for every index built.
+	HashSeed *uint64
+
+	// MaxProbeLength defines the most slots probed to find a key in the hash
+	// tables built, the build fails with ErrProbeTooLong if exceeded. The
+	// records of the same key fill adjacent slots, so the data files of
+	// repeated keys need a limit above their most records of a key.
+	//
+	// The default is 0, no limit, the max probe length is only recorded in
+	// the manifest.
+	MaxProbeLength int
+
+	// VerifyChecksums defines whether the checksums of all hash tables
+	// should be verified when opening the indexes. The headers and footers
+	// are always checked.
+	//
+	// The default is false.
+	VerifyChecksums bool
+
+	// Mmap defines whether the hash tables should be memory mapped, which
+	// probes the slots without syscalls. If mmap is not supported or fails,
+	// the hash tables are read by ReadAt.
+	//
+	// The default is false.
+	Mmap bool
+
+	// MmapAdvice is the madvise hint of the mapped hash tables.
+	//
+	// The default is MmapRandom.
+	MmapAdvice MmapAdvice
+
+	// BuildWorkers defines the goroutines scanning the data file and
+	// generating the hash tables when the indexes are built, the data file
+	// is split into chunks at record boundaries for them.
+	//
+	// The default is runtime.NumCPU().
+	BuildWorkers int
+
+	// BuildMemory defines the memory budget in bytes of building the
+	// indexes, which sizes the buffers of the shards, and the slots of the
+	// hash tables generated in memory. The hash tables exceeding it are
+	// generated in several passes over their tmp files.
+	//
+	// The default is DefaultBuildMemory.
+	BuildMemory int64
+
+	// ShardMemory defines the max bytes of the entries of a shard kept in
+	// memory when the indexes are built. The hash tables of the smaller
+	// shards are generated from memory, the larger shards are written into
+	// tmp files first. Use -1 to write all shards into tmp files.
+	//
+	// The default is the buffer size of a shard, derived from BuildMemory.
+	ShardMemory int64
+
+	// BoundaryFile is the path of a sidecar file of record boundaries,
+	// written by the producer of the data file: the offsets of some records
+	// as little endian uint64, in ascending order. If set, the data file is
+	// split into chunks at these offsets, without a first pass to find the
+	// record boundaries.
+	//
+	// The default is empty.
+	BoundaryFile string
+
+	// Progress receives the progress of building the indexes every
+	// ProgressInterval, and after every phase. It is not called
+	// concurrently, and the reports of a phase are not after the next one.
+	//
+	// The default is nil.
+	Progress func(BuildProgress)
+
+	// ProgressInterval defines how often the progress is reported.
+	//
+	// The default is DefaultProgressInterval.
+	ProgressInterval time.Duration
+
+	// MultiGetWorkers defines the max goroutines of one MultiGet.
+	//
+	// The default is runtime.GOMAXPROCS(0).
+	MultiGetWorkers int
+
+	// Logger receives the log messages of the DB.
+	//
+	// The default writes to the standard logger of package log.
+	Logger Logger
+}
+
+// GetIndexDir returns the index directory for the data file at path.
+func (o *Options) GetIndexDir(path string) string {
+	if o == nil || o.IndexDir == "" {
+		return path + IndexDirSuffix
+	}
+	return o.IndexDir
+}
+
+func (o *Options) GetReadOnly() bool {
+	if o == nil {
+		return false
+	}
+	return o.ReadOnly
+}
+
+func (o *Options) GetErrorIfMissing() bool {
+	if o == nil {
+		return false
+	}
+	return o.ErrorIfMissing
+}
+
+func (o *Options) GetErrorIfExist() bool {
+	if o == nil {
+		return false
+	}
+	return o.ErrorIfExist
+}
+
+func (o *Options) GetRebuild() bool {
+	if o == nil {
+		return false
+	}
+	return o.Rebuild
+}
+
+func (o *Options) GetRebuildIfCorrupt() bool {
+	if o == nil {
+		return false
+	}
+	return o.RebuildIfCorrupt
+}
+
+func (o *Options) GetStalePolicy() StalePolicy {
+	if o == nil {
+		return StaleRebuild
+	}
+	return o.StalePolicy
+}
+
+func (o *Options) GetMaxDeltaEntries() int {
+	if o == nil || o.MaxDeltaEntries == 0 {
+		return DefaultMaxDeltaEntries
+	}
+	return o.MaxDeltaEntries
+}
+
+func (o *Options) GetShardBits() uint {
+	if o == nil || o.ShardBits == 0 {
+		return DefaultShardBits
+	}
+	if o.ShardBits < 0 {
+		return 0
+	}
+	return uint(o.ShardBits)
+}
+
+// GetOffsetWidth returns the offset width for a data file of size.
+func (o *Options) GetOffsetWidth(size int64) int {
+	if o == nil || o.OffsetWidth == 0 {
+		return offsetWidth(size)
+	}
+	return o.OffsetWidth
+}
+
+func (o *Options) GetMaxKeySize() uint64 {
+	if o == nil || o.MaxKeySize <= 0 {
+		return DefaultMaxKeySize
+	}
+	return uint64(o.MaxKeySize)
+}
+
+func (o *Options) GetMaxValueSize() uint64 {
+	if o == nil || o.MaxValueSize <= 0 {
+		return math.MaxUint64
+	}
+	return uint64(o.MaxValueSize)
+}
+
+// GetMaxReadSize returns the max value size read into memory.
+func (o *Options) GetMaxReadSize() uint64 {
+	if o == nil || o.MaxValueSize <= 0 {
+		return DefaultMaxReadSize
+	}
+	return uint64(o.MaxValueSize)
+}
+
+func (o *Options) GetHash() HashFunc {
+	if o == nil || o.Hash == 0 {
+		return DefaultHash
+	}
+	return o.Hash
+}
+
+// GetHashSeed returns the seed of the hash function, random is true if the
+// seed should be random.
+func (o *Options) GetHashSeed() (seed uint64, random bool) {
+	if o == nil || o.HashSeed == nil {
+		return 0, true
+	}
+	return *o.HashSeed, false
+}
+
+// GetMaxProbeLength returns the max probe length, 0 for no limit.
+func (o *Options) GetMaxProbeLength() int {
+	if o == nil || o.MaxProbeLength < 0 {
+		return 0
+	}
+	return o.MaxProbeLength
+}
+
+func (o *Options) GetVerifyChecksums() bool {
+	if o == nil {
+		return false
+	}
+	return o.VerifyChecksums
+}
+
+func (o *Options) GetMmap() bool {
+	if o == nil {
+		return false
+	}
+	return o.Mmap
+}
+
+func (o *Options) GetMmapAdvice() MmapAdvice {
+	if o == nil {
+		return MmapRandom
+	}
+	return o.MmapAdvice
+}
+
+func (o *Options) GetBuildWorkers() int {
+	if o == nil || o.BuildWorkers <= 0 {
+		return runtime.NumCPU()
+	}
+	return o.BuildWorkers
+}
+
+func (o *Options) GetBuildMemory() int64 {
+	if o == nil || o.BuildMemory <= 0 {
+		return DefaultBuildMemory
+	}
+	return o.BuildMemory
+}
+
+// GetShardMemory returns the max bytes of the entries of a shard in memory,
+// bufSize is the buffer size of a shard.
+func (o *Options) GetShardMemory(bufSize int64) int64 {
+	if o == nil || o.ShardMemory == 0 {
+		return bufSize
+	}
+	if o.ShardMemory < 0 {
+		return 0
+	}
+	return o.ShardMemory
+}
+
+func (o *Options) GetBoundaryFile() string {
+	if o == nil {
+		return ""
+	}
+	return o.BoundaryFile
+}
+
+func (o *Options) GetProgress() func(BuildProgress) {
+	if o == nil {
+		return nil
+	}
+	return o.Progress
+}
+
+func (o *Options) GetProgressInterval() time.Duration {
+	if o == nil || o.ProgressInterval <= 0 {
+		return DefaultProgressInterval
+	}
+	return o.ProgressInterval
+}
+
+func (o *Options) GetMultiGetWorkers() int {
+	if o == nil || o.MultiGetWorkers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return o.MultiGetWorkers
+}
+
+func (o *Options) GetLogger() Logger {
+	if o == nil || o.Logger == nil {
+		return log.Default()
+	}
+	return o.Logger
+}
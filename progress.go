@@ -0,0 +1,213 @@
+package zyxindex
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultProgressInterval is the default of Options.ProgressInterval.
+const DefaultProgressInterval = time.Second
+
+// BuildPhase is the phase of building the indexes.
+type BuildPhase int
+
+const (
+	// BuildScanning scans the data file into the shard tmp files.
+	BuildScanning BuildPhase = iota
+	// BuildGenerating generates the hash tables of the shards.
+	BuildGenerating
+	// BuildDone has published the indexes.
+	BuildDone
+)
+
+func (p BuildPhase) String() string {
+	switch p {
+	case BuildScanning:
+		return "scanning"
+	case BuildGenerating:
+		return "generating"
+	case BuildDone:
+		return "done"
+	}
+	return "unknown"
+}
+
+// BuildProgress is the progress of building the indexes, reported to
+// Options.Progress.
+type BuildProgress struct {
+	Phase BuildPhase
+
+	// BytesScanned of TotalBytes in the data file are scanned.
+	BytesScanned int64
+	TotalBytes   int64
+	// Records is the records scanned.
+	Records int64
+
+	// ShardsFinished of Shards hash tables are generated.
+	ShardsFinished int
+	Shards         int
+
+	// Elapsed is the time since the build started.
+	Elapsed time.Duration
+	// Remaining is the estimated time remaining of the current phase,
+	// 0 if unknown.
+	Remaining time.Duration
+}
+
+// scanCheckRecords is how often a scan reports its progress and checks
+// the context, in records.
+const scanCheckRecords = 1024
+
+// progress tracks the progress of a build, and reports it every interval.
+// A nil *progress tracks nothing.
+type progress struct {
+	fn       func(BuildProgress)
+	interval time.Duration
+	start    time.Time
+	total    int64
+	shards   int
+
+	phase          int32
+	phaseStart     int64
+	bytes          int64
+	records        int64
+	shardsFinished int64
+
+	// mu serializes the calls of fn, a phase is reported after the
+	// snapshots of the previous phase.
+	mu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newProgress starts reporting the progress to fn, it returns nil if fn is nil.
+func newProgress(fn func(BuildProgress), interval time.Duration, total int64, shards int) *progress {
+	if fn == nil {
+		return nil
+	}
+	now := time.Now()
+	p := &progress{
+		fn:         fn,
+		interval:   interval,
+		start:      now,
+		phaseStart: now.UnixNano(),
+		total:      total,
+		shards:     shards,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *progress) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.report()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// report calls fn with a snapshot of the progress.
+func (p *progress) report() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fn(p.snapshot())
+}
+
+func (p *progress) snapshot() BuildProgress {
+	now := time.Now()
+	bp := BuildProgress{
+		Phase:          BuildPhase(atomic.LoadInt32(&p.phase)),
+		BytesScanned:   atomic.LoadInt64(&p.bytes),
+		TotalBytes:     p.total,
+		Records:        atomic.LoadInt64(&p.records),
+		ShardsFinished: int(atomic.LoadInt64(&p.shardsFinished)),
+		Shards:         p.shards,
+		Elapsed:        now.Sub(p.start),
+	}
+	phaseElapsed := now.Sub(time.Unix(0, atomic.LoadInt64(&p.phaseStart)))
+	switch bp.Phase {
+	case BuildScanning:
+		if bp.BytesScanned > 0 {
+			bp.Remaining = time.Duration(float64(phaseElapsed) *
+				float64(bp.TotalBytes-bp.BytesScanned) / float64(bp.BytesScanned))
+		}
+	case BuildGenerating:
+		if bp.ShardsFinished > 0 {
+			bp.Remaining = phaseElapsed * time.Duration(bp.Shards-bp.ShardsFinished) /
+				time.Duration(bp.ShardsFinished)
+		}
+	}
+	return bp
+}
+
+// setPhase starts the next phase, and reports it.
+func (p *progress) setPhase(phase BuildPhase) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	atomic.StoreInt64(&p.phaseStart, time.Now().UnixNano())
+	atomic.StoreInt32(&p.phase, int32(phase))
+	p.fn(p.snapshot())
+}
+
+// finish stops reporting, the last report is BuildDone if err is nil.
+func (p *progress) finish(err error) {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	if err == nil {
+		p.setPhase(BuildDone)
+	}
+}
+
+func (p *progress) addShard() {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.shardsFinished, 1)
+}
+
+// scanCounter counts the records scanned by one goroutine into progress in
+// batches, and checks ctx.
+type scanCounter struct {
+	ctx     context.Context
+	p       *progress
+	records int64
+	// offset is the offset counted into progress.
+	offset int64
+}
+
+// count counts the record at offset, it returns ctx.Err() if ctx is done.
+func (c *scanCounter) count(offset int64) error {
+	c.records++
+	if c.records < scanCheckRecords {
+		return nil
+	}
+	c.flush(offset)
+	return c.ctx.Err()
+}
+
+// flush counts the records and the bytes before offset into progress.
+func (c *scanCounter) flush(offset int64) {
+	if c.p != nil {
+		atomic.AddInt64(&c.p.records, c.records)
+		atomic.AddInt64(&c.p.bytes, offset-c.offset)
+	}
+	c.records = 0
+	c.offset = offset
+}
@@ -0,0 +1,112 @@
+package zyxindex
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBuild(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	for i := 0; i < 5000; i++ {
+		writeRecord(file, fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	info, _ := file.Stat()
+	file.Close()
+
+	var mu sync.Mutex
+	var reports []BuildProgress
+	o := &Options{
+		ShardBits:        4,
+		Logger:           &testLogger{},
+		ProgressInterval: time.Millisecond,
+		Progress: func(p BuildProgress) {
+			mu.Lock()
+			reports = append(reports, p)
+			mu.Unlock()
+		},
+	}
+	if err := Build(context.Background(), dataPath, o); err != nil {
+		t.Fatal("build failed", err)
+	}
+	last := reports[len(reports)-1]
+	if last.Phase != BuildDone || last.Records != 5000 || last.BytesScanned != info.Size() ||
+		last.TotalBytes != info.Size() || last.ShardsFinished != 16 || last.Shards != 16 {
+		t.Errorf("%+v should be done", last)
+	}
+	phase := BuildScanning
+	for _, p := range reports {
+		if p.Phase < phase {
+			t.Errorf("%v should not be after %v", p.Phase, phase)
+		}
+		phase = p.Phase
+	}
+	db, err := OpenWithOptions(dataPath, &Options{ErrorIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Get([]byte("key42")); err != nil || string(v) != "value42" {
+		t.Errorf("%q should equal %q, %v", v, "value42", err)
+	}
+	db.Close()
+
+	// a cancelled build keeps the indexes.
+	defer func(size int64) { minChunkSize = size }(minChunkSize)
+	for _, size := range []int64{minChunkSize, 100} {
+		minChunkSize = size
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = Build(ctx, dataPath, &Options{BuildWorkers: 2, Logger: &testLogger{}})
+		if err != context.Canceled {
+			t.Errorf("%v should equal expected(%v)", err, context.Canceled)
+		}
+		generation, _ := readCurrent(testIndexDir)
+		if generation != 1 {
+			t.Errorf("%v should equal expected(%v)", generation, 1)
+		}
+		if paths, _ := filepath.Glob(GenerationPath(testIndexDir, 2) + "*"); len(paths) != 0 {
+			t.Errorf("%v should be removed", paths)
+		}
+	}
+}
+
+func TestProgress_Serialized(t *testing.T) {
+	var running, overlapped int32
+	var phases []BuildPhase
+	p := newProgress(func(bp BuildProgress) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+		phases = append(phases, bp.Phase)
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	}, time.Millisecond, 100, 1)
+	time.Sleep(10 * time.Millisecond)
+	p.setPhase(BuildGenerating)
+	time.Sleep(10 * time.Millisecond)
+	p.finish(nil)
+	if overlapped != 0 {
+		t.Error("the progress should not be reported concurrently")
+	}
+	phase := BuildScanning
+	for _, ph := range phases {
+		if ph < phase {
+			t.Errorf("%v should not be after %v", ph, phase)
+		}
+		phase = ph
+	}
+	if phase != BuildDone {
+		t.Errorf("%v should equal expected(%v)", phase, BuildDone)
+	}
+}
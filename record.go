@@ -0,0 +1,165 @@
+package zyxindex
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// recordBufferSize is the read buffer of recordDecoder.
+const recordBufferSize = 64 << 10
+
+// recordDecoder decodes the records of the data file in file order:
+// (keysize: uint64, key: bytes, valuesize: uint64, value: bytes)
+// It is shared by building the indexes and Iterator, so they parse the data
+// file by the same rules.
+type recordDecoder struct {
+	file *io.SectionReader
+	r    *bufio.Reader
+	from int64
+	to   int64
+	// tail is true if to is the end of the data file, where a record may be
+	// being appended.
+	tail bool
+	// offset of the next record.
+	offset int64
+
+	skipValues bool
+	// the max key size and value size of a valid record.
+	maxKeySize   uint64
+	maxValueSize uint64
+
+	head  [sizeOfuint64]byte
+	key   []byte
+	value []byte
+}
+
+// newRecordDecoder decodes the records in [from, to) of r, the values are
+// not read if skipValues is true. The records exceeding o.MaxKeySize or
+// o.MaxValueSize are corrupt. tail is true if to is the end of the data
+// file, then a record crossing to is being appended, otherwise it is corrupt.
+func newRecordDecoder(r io.ReaderAt, from, to int64, tail, skipValues bool, o *Options) *recordDecoder {
+	file := io.NewSectionReader(r, from, to-from)
+	return &recordDecoder{
+		file:         file,
+		r:            bufio.NewReaderSize(file, recordBufferSize),
+		from:         from,
+		to:           to,
+		tail:         tail,
+		offset:       from,
+		skipValues:   skipValues,
+		maxKeySize:   o.GetMaxKeySize(),
+		maxValueSize: o.GetMaxValueSize(),
+	}
+}
+
+// next decodes the next record. key and value are valid until the next call,
+// value is nil if the values are skipped.
+// @return err, io.EOF after the last record. A record crossing to is not
+// decoded, it ends the records at the tail, it may be being appended.
+// *RecordError if the key size or the value size exceeds the max, or a
+// record crosses to before the tail.
+func (d *recordDecoder) next() (offset int64, key, value []byte, err error) {
+	keySize, err := d.readUint64()
+	if err != nil {
+		return
+	}
+	if keySize > d.maxKeySize {
+		return 0, nil, nil, newRecordError(d.offset, "key size %d exceeds %d", keySize, d.maxKeySize)
+	}
+	if keySize > uint64(d.to-d.offset) {
+		return 0, nil, nil, d.crossing("key size %d crosses the end %d", keySize, d.to)
+	}
+	if uint64(cap(d.key)) < keySize {
+		d.key = make([]byte, keySize)
+	}
+	key = d.key[:keySize]
+	_, err = io.ReadFull(d.r, key)
+	if err != nil {
+		return 0, nil, nil, d.eof(err)
+	}
+	valueSize, err := d.readUint64()
+	if err != nil {
+		return
+	}
+	if valueSize > d.maxValueSize {
+		return 0, nil, nil, newRecordError(d.offset, "value size %d exceeds %d", valueSize, d.maxValueSize)
+	}
+	next := d.offset + 2*sizeOfuint64 + int64(keySize)
+	if valueSize > uint64(d.to-next) {
+		return 0, nil, nil, d.crossing("value size %d crosses the end %d", valueSize, d.to)
+	}
+	next += int64(valueSize)
+	if d.skipValues {
+		err = d.skip(int(valueSize), next)
+	} else {
+		if uint64(cap(d.value)) < valueSize {
+			d.value = make([]byte, valueSize)
+		}
+		value = d.value[:valueSize]
+		_, err = io.ReadFull(d.r, value)
+	}
+	if err != nil {
+		return 0, nil, nil, d.eof(err)
+	}
+	offset, d.offset = d.offset, next
+	return
+}
+
+func (d *recordDecoder) readUint64() (v uint64, err error) {
+	_, err = io.ReadFull(d.r, d.head[:])
+	if err != nil {
+		return 0, d.eof(err)
+	}
+	return binary.LittleEndian.Uint64(d.head[:]), nil
+}
+
+// skip skips the value of n bytes, next is the offset after it.
+func (d *recordDecoder) skip(n int, next int64) (err error) {
+	if n <= d.r.Buffered() {
+		_, err = d.r.Discard(n)
+		return
+	}
+	// seek over the large value instead of reading it.
+	_, err = d.file.Seek(next-d.from, io.SeekStart)
+	d.r.Reset(d.file)
+	return
+}
+
+// eof treats a partial record as the end of the records at the tail.
+func (d *recordDecoder) eof(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return d.crossing("partial record at the end %d", d.to)
+	}
+	return err
+}
+
+// crossing returns io.EOF for the record crossing to at the tail, or a
+// *RecordError otherwise.
+func (d *recordDecoder) crossing(format string, v ...interface{}) error {
+	if d.tail {
+		return io.EOF
+	}
+	return newRecordError(d.offset, format, v...)
+}
+
+// RecordError is returned when a record of the data file is corrupt, it
+// matches ErrCorruptRecord by errors.Is.
+type RecordError struct {
+	// Offset is the offset of the record in the data file.
+	Offset int64
+	Reason string
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("zyxindex: corrupt record at offset %d: %s", e.Offset, e.Reason)
+}
+
+func (e *RecordError) Unwrap() error {
+	return ErrCorruptRecord
+}
+
+func newRecordError(offset int64, format string, v ...interface{}) *RecordError {
+	return &RecordError{Offset: offset, Reason: fmt.Sprintf(format, v...)}
+}
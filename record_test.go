@@ -0,0 +1,188 @@
+package zyxindex
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecordDecoder_MaxSize(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "123", "456")
+	writeRecord(file, strings.Repeat("k", 100), "long key")
+	writeRecord(file, "large", strings.Repeat("v", 100))
+	file.Close()
+
+	for _, c := range []struct {
+		o      *Options
+		offset int64
+	}{
+		{&Options{MaxKeySize: 99}, 8 + 3 + 8 + 3},
+		{&Options{MaxValueSize: 99}, 8 + 3 + 8 + 3 + 8 + 100 + 8 + 8},
+	} {
+		_, err := OpenWithOptions(dataPath, c.o)
+		var recordErr *RecordError
+		if !errors.Is(err, ErrCorruptRecord) || !errors.As(err, &recordErr) || recordErr.Offset != c.offset {
+			t.Errorf("%v should be at offset %v", err, c.offset)
+		}
+	}
+
+	db, err := OpenWithOptions(dataPath, &Options{MaxKeySize: 100, MaxValueSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, err := db.Get([]byte("large")); err != nil || len(v) != 100 {
+		t.Errorf("%v should have 100 bytes, %v", len(v), err)
+	}
+	if _, err := db.Get([]byte(strings.Repeat("k", 101))); err != os.ErrNotExist {
+		t.Errorf("%v should equal expected(%v)", err, os.ErrNotExist)
+	}
+
+	// the value size of a record is damaged after the indexes are built.
+	file, err = os.OpenFile(dataPath, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(8 + 3 + 8 + 3 + 8 + 100 + 8 + 8)
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, 1<<62)
+	file.WriteAt(b, offset+8+5)
+	file.Close()
+	_, err = db.Get([]byte("large"))
+	var recordErr *RecordError
+	if !errors.Is(err, ErrCorruptRecord) || !errors.As(err, &recordErr) || recordErr.Offset != offset {
+		t.Errorf("%v should be at offset %v", err, offset)
+	}
+	if _, err := db.Gets([]byte("large")); !errors.Is(err, ErrCorruptRecord) {
+		t.Errorf("%v should equal expected(%v)", err, ErrCorruptRecord)
+	}
+	if _, errs := db.MultiGet([][]byte{[]byte("large")}); !errors.Is(errs[0], ErrCorruptRecord) {
+		t.Errorf("%v should equal expected(%v)", errs[0], ErrCorruptRecord)
+	}
+}
+
+func TestRecordDecoder_Crossing(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	defer func(size int64) { minChunkSize = size }(minChunkSize)
+	minChunkSize = 100
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	var offsets []int64
+	offset := int64(0)
+	for i := 0; i < 20; i++ {
+		k, v := fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)
+		offsets = append(offsets, offset)
+		writeRecord(file, k, v)
+		offset += int64(16 + len(k) + len(v))
+	}
+	db, err := OpenWithOptions(dataPath, &Options{Logger: &testLogger{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the value size of a record in the middle crosses the end of the file.
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(offset))
+	file.WriteAt(b, offsets[10]+8+5)
+	file.Close()
+
+	for _, tail := range []bool{true, false} {
+		d := newRecordDecoder(db.file, 0, offset, tail, true, nil)
+		n := 0
+		for ; ; n++ {
+			_, _, _, err = d.next()
+			if err != nil {
+				break
+			}
+		}
+		var recordErr *RecordError
+		switch {
+		case n != 10:
+			t.Errorf("%v: %v should equal expected(%v)", tail, n, 10)
+		case tail && err != io.EOF:
+			t.Errorf("%v should equal expected(%v)", err, io.EOF)
+		case !tail && (!errors.As(err, &recordErr) || recordErr.Offset != offsets[10]):
+			t.Errorf("%v should be at offset %v", err, offsets[10])
+		}
+	}
+
+	_, err = db.Verify(context.Background(), nil)
+	var recordErr *RecordError
+	if !errors.As(err, &recordErr) || recordErr.Offset != offsets[10] {
+		t.Errorf("%v should be at offset %v", err, offsets[10])
+	}
+
+	// the record crosses the end of a chunk, which is not the tail.
+	boundaries, err := os.Create(testDir + "/boundaries")
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	binary.Write(boundaries, binary.LittleEndian, uint64(offsets[15]))
+	boundaries.Close()
+	_, err = OpenWithOptions(dataPath, &Options{BuildWorkers: 2, BoundaryFile: testDir + "/boundaries",
+		Rebuild: true, Logger: &testLogger{}})
+	if !errors.As(err, &recordErr) || recordErr.Offset != offsets[10] {
+		t.Errorf("%v should be at offset %v", err, offsets[10])
+	}
+}
+
+func TestDB_LargeValue(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	// a sparse value larger than DefaultMaxReadSize.
+	size := int64(DefaultMaxReadSize + 1<<20)
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, 5)
+	file.Write(b)
+	file.WriteString("large")
+	binary.LittleEndian.PutUint64(b, uint64(size))
+	file.Write(b)
+	file.Truncate(8 + 5 + 8 + size)
+	file.Seek(0, io.SeekEnd)
+	writeRecord(file, "small", "value")
+	file.Close()
+
+	db, err := OpenWithOptions(dataPath, &Options{Logger: &testLogger{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if vr, err := db.OpenValue([]byte("large")); err != nil || vr.Size() != size {
+		t.Errorf("%v should have %v bytes, %v", vr, size, err)
+	}
+	if v, err := db.GetRange([]byte("large"), size-2, 10); err != nil || len(v) != 2 {
+		t.Errorf("%v should have 2 bytes, %v", len(v), err)
+	}
+	if _, err := db.Get([]byte("large")); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("%v should equal expected(%v)", err, ErrValueTooLarge)
+	}
+	if _, err := db.Gets([]byte("large")); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("%v should equal expected(%v)", err, ErrValueTooLarge)
+	}
+	values, errs := db.MultiGet([][]byte{[]byte("large"), []byte("small")})
+	if !errors.Is(errs[0], ErrValueTooLarge) || errs[1] != nil || string(values[1]) != "value" {
+		t.Errorf("%v %q should be too large and %q", errs, values, "value")
+	}
+}
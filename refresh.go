@@ -0,0 +1,252 @@
+package zyxindex
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+)
+
+/*
+	refresh indexes for append-only data files.
+
+	The base shards index the records before Manifest.BaseSize, the records
+	appended later are indexed by a delta index, which is one hash table in
+	the same format:
+
+		{$dir}/gen-{$base_generation}/hashTable{$shardId}    the base shards
+		{$dir}/gen-{$delta_generation}/hashTable0            the delta
+
+	Refresh scans only the appended tail, and folds the old delta and the tail
+	into the delta of a new generation. Merge folds the delta back into the base
+	shards of a new generation.
+*/
+
+// Refresh indexes the records appended to the data file since the indexes
+// were built or refreshed. A record being appended is indexed by the next
+// Refresh. If the delta exceeds MaxDeltaEntries, it is merged into the base
+// shards.
+//
+// Refresh returns ErrStaleIndex if the data file changed other than appending,
+// and ErrReadOnly in read-only mode.
+func (db *DB) Refresh() (err error) {
+	if db.o.GetReadOnly() {
+		return ErrReadOnly
+	}
+	db.updateMu.Lock()
+	defer db.updateMu.Unlock()
+
+	manifest := db.manifest
+	appended, reason, err := manifest.checkData(db.file)
+	if err != nil {
+		return
+	}
+	if reason != "" {
+		return fmt.Errorf("%w: %s", ErrStaleIndex, reason)
+	}
+	if !appended {
+		return
+	}
+	info, err := db.file.Stat()
+	if err != nil {
+		return
+	}
+	if offsetOverflow(uint64(info.Size()-1), manifest.OffsetWidth) {
+		return fmt.Errorf("%w: data file size %d exceeds %d bytes offsets, rebuild the indexes",
+			ErrOffsetOverflow, info.Size(), manifest.OffsetWidth)
+	}
+
+	generation, err := newGeneration(db.indexDir)
+	if err != nil {
+		return
+	}
+	dir := GenerationPath(db.indexDir, generation)
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	builder, err := NewShardsBuilder(dir, 0, manifest.OffsetWidth, db.o)
+	if err != nil {
+		return
+	}
+	builder.setHash(manifest.hashFunc())
+	// fold the old delta, whose records are before the tail.
+	if old := db.shards.delta; old != nil {
+		err = rangeByOffset(old.tables[0], builder.builders[0].Put)
+		if err != nil {
+			builder.Abort()
+			return
+		}
+	}
+	end, err := db.scanChunks(context.Background(), manifest.DataSize, info.Size(), true, builder, nil)
+	if err != nil {
+		builder.Abort()
+		return
+	}
+	db.logPartial(end, info.Size())
+	entries := builder.builders[0].keycount
+	delta, err := builder.BuildShards()
+	if err != nil {
+		return
+	}
+	if end == manifest.DataSize {
+		// nothing but a part of a record is appended.
+		delta.Close()
+		return os.RemoveAll(dir)
+	}
+
+	refreshed := *manifest
+	refreshed.Generation = generation
+	refreshed.DeltaGeneration = generation
+	refreshed.DeltaEntries = entries
+	err = refreshed.setData(db.file, end, info)
+	if err == nil {
+		err = publish(db.indexDir, &refreshed)
+	}
+	if err != nil {
+		delta.Close()
+		return
+	}
+
+	db.mu.Lock()
+	old := db.shards.delta
+	db.shards.delta = &delta
+	db.manifest = &refreshed
+	db.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	db.removeObsolete()
+	db.logger.Printf("refresh indexes in %s: %d bytes appended, %d entries in delta",
+		db.indexDir, end-manifest.DataSize, entries)
+
+	if max := db.o.GetMaxDeltaEntries(); max >= 0 && entries > max {
+		return db.merge()
+	}
+	return
+}
+
+// Merge folds the delta index into the base shards.
+// It returns ErrReadOnly in read-only mode.
+func (db *DB) Merge() error {
+	if db.o.GetReadOnly() {
+		return ErrReadOnly
+	}
+	db.updateMu.Lock()
+	defer db.updateMu.Unlock()
+	return db.merge()
+}
+
+func (db *DB) merge() (err error) {
+	manifest := db.manifest
+	if manifest.DeltaEntries == 0 {
+		return
+	}
+	shardBits, _ := shardBitsOf(manifest.ShardNum)
+	generation, err := newGeneration(db.indexDir)
+	if err != nil {
+		return
+	}
+	dir := GenerationPath(db.indexDir, generation)
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	builder, err := NewShardsBuilder(dir, shardBits, manifest.OffsetWidth, db.o)
+	if err != nil {
+		return
+	}
+	builder.setHash(manifest.hashFunc())
+	for i, table := range db.shards.tables {
+		err = rangeByOffset(table, builder.builders[i].Put)
+		if err != nil {
+			builder.Abort()
+			return
+		}
+	}
+	// the delta has no shard bits of the keys, so scan its records again.
+	_, err = db.scanChunks(context.Background(), manifest.BaseSize, manifest.DataSize, false, builder, nil)
+	if err != nil {
+		builder.Abort()
+		return
+	}
+	base, err := builder.BuildShards()
+	if err != nil {
+		return
+	}
+
+	merged := *manifest
+	merged.Generation = generation
+	merged.BaseSize = manifest.DataSize
+	merged.BaseGeneration = generation
+	merged.DeltaEntries = 0
+	merged.MaxProbeLength = builder.MaxProbeLength()
+	err = publish(db.indexDir, &merged)
+	if err != nil {
+		base.Close()
+		return
+	}
+
+	db.mu.Lock()
+	old := db.shards
+	db.shards = base
+	db.manifest = &merged
+	db.mu.Unlock()
+	old.Close()
+	db.removeObsolete()
+	db.logger.Printf("merge %d delta entries into indexes in %s", manifest.DeltaEntries, db.indexDir)
+	return
+}
+
+// rangeByOffset is Range in the ascending order of the offsets, which is the
+// file order of the records. Range walks the slots in slot order, so the
+// values of a key whose probe chain wraps from the last slot to the first
+// come out of order, rebuilding a table from them would reorder Gets.
+func rangeByOffset(table HashTabler, fn func(k, v []byte) error) (err error) {
+	var slots []byte
+	slotLen := 0
+	err = table.Range(func(k, v []byte) error {
+		slotLen = len(k) + len(v)
+		slots = append(append(slots, k...), v...)
+		return nil
+	})
+	if err != nil || slotLen == 0 {
+		return
+	}
+	s := &slotsByOffset{slots: slots, slotLen: slotLen, tmp: make([]byte, slotLen)}
+	sort.Sort(s)
+	for i := 0; i < len(slots); i += slotLen {
+		err = fn(slots[i:i+kLen], slots[i+kLen:i+slotLen])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// slotsByOffset sorts the slots by their offsets.
+type slotsByOffset struct {
+	slots   []byte
+	slotLen int
+	tmp     []byte
+}
+
+func (s *slotsByOffset) Len() int {
+	return len(s.slots) / s.slotLen
+}
+
+func (s *slotsByOffset) Less(i, j int) bool {
+	return littleEndianOffset(s.slot(i)[kLen:]) < littleEndianOffset(s.slot(j)[kLen:])
+}
+
+func (s *slotsByOffset) Swap(i, j int) {
+	copy(s.tmp, s.slot(i))
+	copy(s.slot(i), s.slot(j))
+	copy(s.slot(j), s.tmp)
+}
+
+func (s *slotsByOffset) slot(i int) []byte {
+	return s.slots[i*s.slotLen : (i+1)*s.slotLen]
+}
@@ -0,0 +1,181 @@
+package zyxindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestDB_Refresh(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "123", "456")
+	writeRecord(file, "helloworld", "!")
+	db, err := OpenWithOptions(dataPath, &Options{ShardBits: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	get := func(k string, expected ...string) {
+		t.Helper()
+		values, err := db.Gets([]byte(k))
+		if err != nil {
+			t.Errorf("%v: gets failed, %v", k, err)
+		}
+		if len(values) != len(expected) {
+			t.Fatalf("%v: %q should equal %q", k, values, expected)
+		}
+		for i := range values {
+			if string(values[i]) != expected[i] {
+				t.Errorf("%v: %q should equal %q", k, values, expected)
+			}
+		}
+	}
+
+	writeRecord(file, "abc", "def")
+	writeRecord(file, "123", "789")
+	// a record being appended
+	binary.Write(file, binary.LittleEndian, uint64(3))
+	if err := db.Refresh(); err != nil {
+		t.Fatal("refresh failed", err)
+	}
+	get("abc", "def")
+	get("123", "456", "789")
+	if db.manifest.DeltaEntries != 2 {
+		t.Errorf("%v should equal expected(%v)", db.manifest.DeltaEntries, 2)
+	}
+
+	file.Write([]byte("xyz"))
+	binary.Write(file, binary.LittleEndian, uint64(1))
+	file.Write([]byte("0"))
+	if err := db.Refresh(); err != nil {
+		t.Fatal("refresh failed", err)
+	}
+	get("xyz", "0")
+	get("abc", "def")
+	if db.manifest.DeltaEntries != 3 {
+		t.Errorf("%v should equal expected(%v)", db.manifest.DeltaEntries, 3)
+	}
+	if _, err := os.Stat(GenerationPath(testIndexDir, 2)); !os.IsNotExist(err) {
+		t.Error("the old delta should be removed", err)
+	}
+
+	if err := db.Merge(); err != nil {
+		t.Fatal("merge failed", err)
+	}
+	if db.shards.delta != nil || db.manifest.BaseSize != db.manifest.DataSize {
+		t.Errorf("%+v: delta should be merged", db.manifest)
+	}
+	get("xyz", "0")
+	get("123", "456", "789")
+	get("helloworld", "!")
+	if _, err := os.Stat(GenerationPath(testIndexDir, 3)); !os.IsNotExist(err) {
+		t.Error("the delta should be removed", err)
+	}
+
+	// Open refreshes the appended records, and merges the large delta.
+	writeRecord(file, "new", "record")
+	file.Close()
+	db2, err := OpenWithOptions(dataPath, &Options{MaxDeltaEntries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := db2.Get([]byte("new"))
+	if err != nil || string(value) != "record" {
+		t.Errorf("%q should equal %q, %v", value, "record", err)
+	}
+	if db2.manifest.DeltaEntries != 1 {
+		t.Errorf("%v should equal expected(%v)", db2.manifest.DeltaEntries, 1)
+	}
+	db2.Close()
+
+	file, err = os.OpenFile(dataPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecord(file, "newer", "record")
+	file.Close()
+	db2, err = OpenWithOptions(dataPath, &Options{MaxDeltaEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	if db2.manifest.DeltaEntries != 0 {
+		t.Errorf("%+v: delta should be merged", db2.manifest)
+	}
+	for _, k := range []string{"new", "newer"} {
+		value, err := db2.Get([]byte(k))
+		if err != nil || string(value) != "record" {
+			t.Errorf("%q should equal %q, %v", value, "record", err)
+		}
+	}
+}
+
+func TestDB_RefreshStale(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "123", "456")
+	file.Close()
+	db, err := Open(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	file, err = os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	writeRecord(file, "abc", "def")
+	writeRecord(file, "123", "456")
+	file.Close()
+	if err := db.Refresh(); !errors.Is(err, ErrStaleIndex) {
+		t.Error("indexes should be stale", err)
+	}
+}
+
+// wrappedSource reads the key 15 with the offsets in order.
+type wrappedSource struct {
+	offsets []uint64
+	index   int
+}
+
+func (s *wrappedSource) readNext(k, v []byte) (err error) {
+	littleEndianPutKey(k, 15)
+	littleEndianPutOffset(v, s.offsets[s.index])
+	s.index++
+	return nil
+}
+
+func TestRangeByOffset(t *testing.T) {
+	// 16 slots, the probe chain of the key wraps from slot 15 to 0 and 1,
+	// so Range returns 2, 3, 1.
+	source := &wrappedSource{offsets: []uint64{1, 2, 3}}
+	buffer := new(bytes.Buffer)
+	if err := Generate(source, len(source.offsets), minVLen, buffer); err != nil {
+		t.Fatal(err)
+	}
+	h, err := OpenHashTable(bytes.NewReader(buffer.Bytes()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []uint64
+	err = rangeByOffset(h, func(k, v []byte) error {
+		offsets = append(offsets, littleEndianOffset(v))
+		return nil
+	})
+	if err != nil || len(offsets) != 3 || offsets[0] != 1 || offsets[1] != 2 || offsets[2] != 3 {
+		t.Errorf("%v should equal expected([1 2 3]), %v", offsets, err)
+	}
+}
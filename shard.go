@@ -1,12 +1,20 @@
 package zyxindex
 
-import "os"
+import (
+	"fmt"
+	"os"
+)
 
 /*
 	divided hash64 into diffent shard.
 */
 
-const shardMusk = 8
+const (
+	// DefaultShardBits is the shard bits of a DB built with default options, 256 shards.
+	DefaultShardBits = 8
+	// MaxShardBits is the max shard bits, 65536 shards.
+	MaxShardBits = 16
+)
 
 // parse key from bytes to uint64
 func littleEndianKey(b []byte) uint64 {
@@ -26,69 +34,199 @@ func littleEndianPutKey(b []byte, k uint64) {
 	b[6] = byte(k >> 48)
 }
 
-//parse offset from bytes to uint64
-func littleEndianOffset(b []byte) uint64 {
-	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
-		uint64(b[4])<<32
+//parse offset from bytes to uint64, the width of offset is len(b).
+func littleEndianOffset(b []byte) (v uint64) {
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return
 }
 
-// parse offset from uint64 to bytes
+// parse offset from uint64 to bytes, the width of offset is len(b).
 func littleEndianPutOffset(b []byte, v uint64) {
-	_ = b[4] // early bounds check to guarantee safety of writes below
-	b[0] = byte(v)
-	b[1] = byte(v >> 8)
-	b[2] = byte(v >> 16)
-	b[3] = byte(v >> 24)
-	b[4] = byte(v >> 32)
+	for i := range b {
+		b[i] = byte(v)
+		v >>= 8
+	}
+}
+
+// offsetWidth returns the min width of offsets in a data file of size,
+// no less than 5 bytes.
+func offsetWidth(size int64) int {
+	width := minVLen
+	for ; width < maxVLen; width++ {
+		if uint64(size) <= 1<<(8*uint(width)) {
+			break
+		}
+	}
+	return width
+}
+
+// offsetOverflow reports whether the offset can't be stored in width bytes.
+func offsetOverflow(offset uint64, width int) bool {
+	return width < maxVLen && offset >= 1<<(8*uint(width))
 }
 
 type HashTabler interface {
 	Get(k []byte) (v []byte, err error)
+	Gets(k []byte) (vs [][]byte, err error)
+	// Range calls fn with every k and v in the hash table.
+	Range(fn func(k, v []byte) error) error
 	Close() error
 }
 
-type Shards [1 << shardMusk]HashTabler
+// Shards is the hash tables of all shards.
+// The high shardBits bits of hash64 choose the shard.
+type Shards struct {
+	shardBits uint
+	tables    []HashTabler
+
+	// delta indexes the records appended after the shards were built, if not nil.
+	delta *Shards
+}
+
+func newShards(shardBits uint) Shards {
+	return Shards{
+		shardBits: shardBits,
+		tables:    make([]HashTabler, 1<<shardBits),
+	}
+}
+
+// shardBitsOf returns the shard bits of shardNum,
+// ok is false if shardNum is not a power of 2 in range.
+func shardBitsOf(shardNum int) (shardBits uint, ok bool) {
+	for ; shardBits <= MaxShardBits; shardBits++ {
+		if 1<<shardBits == shardNum {
+			return shardBits, true
+		}
+	}
+	return 0, false
+}
 
 // load shards from manifest.
 // manifest must not be null
-func LoadFromManifest(dir string, manifest *Manifest) (shards Shards, err error) {
-	if manifest.Version != version {
-		panic("unknown version")
+// dir is the index directory of the generations.
+// The checksums of hash tables are verified if o.VerifyChecksums is true.
+// Nothing is left open on error.
+// @return err, ErrVersionMismatch if the version of manifest is not supported,
+// ErrShardMissing if a hash table is missing, ErrCorruptIndex if manifest or a
+// hash table is damaged.
+func LoadFromManifest(dir string, manifest *Manifest, o *Options) (shards Shards, err error) {
+	if manifest.Version < minVersion || manifest.Version > version {
+		return Shards{}, fmt.Errorf("%w: manifest version %d, supported %d to %d",
+			ErrVersionMismatch, manifest.Version, minVersion, version)
 	}
-	if manifest.ShardNum != 1<<shardMusk {
-		panic("shardnum not equal")
+	shardBits, ok := shardBitsOf(manifest.ShardNum)
+	if !ok {
+		return Shards{}, fmt.Errorf("%w: invalid shard count %d", ErrCorruptIndex, manifest.ShardNum)
 	}
-	for i := 0; i < 1<<shardMusk; i++ {
-		f, e := os.Open(HashTablePath(dir, i))
+	shards, err = loadShards(GenerationPath(dir, manifest.BaseGeneration), shardBits, manifest, o)
+	if err != nil || manifest.DeltaEntries == 0 {
+		return
+	}
+	delta, err := loadShards(GenerationPath(dir, manifest.DeltaGeneration), 0, manifest, o)
+	if err != nil {
+		shards.Close()
+		return Shards{}, err
+	}
+	shards.delta = &delta
+	return
+}
+
+func loadShards(dir string, shardBits uint, manifest *Manifest, o *Options) (shards Shards, err error) {
+	shards = newShards(shardBits)
+	defer func() {
 		if err != nil {
-			err = e
-			return
+			shards.Close()
+			shards = Shards{}
 		}
-		hashtable, e := OpenHashTable(f)
-		if err != nil {
-			err = e
-			return
+	}()
+	for i := range shards.tables {
+		path := HashTablePath(dir, i)
+		f, e := os.Open(path)
+		if os.IsNotExist(e) {
+			return shards, fmt.Errorf("%w: %s", ErrShardMissing, path)
 		}
-		shards[i] = hashtable
+		if e != nil {
+			return shards, e
+		}
+		hashtable, e := openHashTable(f, manifest.OffsetWidth, manifest.hashFunc(), o.GetVerifyChecksums(), o)
+		if e != nil {
+			return shards, e
+		}
+		shards.tables[i] = hashtable
 	}
 	return
 }
 
 //calcShard calculates shardId and key in this shard.
-func calcShard(hash64 uint64) (shardId int, key []byte) {
+func calcShard(hash64 uint64, shardBits uint) (shardId int, key []byte) {
 	key = make([]byte, kLen)
 	littleEndianPutKey(key, hash64)
-	shardId = int(hash64 >> (64 - shardMusk))
+	shardId = shardIdOf(hash64, shardBits)
 	return
 }
 
+// shardIdOf calculates shardId of hash64 without allocations.
+func shardIdOf(hash64 uint64, shardBits uint) int {
+	return int(hash64 >> (64 - shardBits))
+}
+
 // Get gets the offset of the key hashd
+// The records in delta are after the records in shards, so the delta is
+// consulted only if the shards have no offset of hash64, as Gets.
 func (shards *Shards) Get(hash64 uint64) (offset uint64, err error) {
-	shardId, key := calcShard(hash64)
-	v, err := shards[shardId].Get(key)
+	shardId, key := calcShard(hash64, shards.shardBits)
+	v, err := shards.tables[shardId].Get(key)
+	if err == os.ErrNotExist && shards.delta != nil {
+		return shards.delta.Get(hash64)
+	}
 	if err != nil {
 		return
 	}
 	offset = littleEndianOffset(v)
 	return
 }
+
+// Gets gets offsets of all keys hashed to hash64, in file order.
+// Different keys may have the same hash64, the caller must check the records.
+func (shards *Shards) Gets(hash64 uint64) (offsets []uint64, err error) {
+	shardId, key := calcShard(hash64, shards.shardBits)
+	vs, err := shards.tables[shardId].Gets(key)
+	if err != nil && err != os.ErrNotExist {
+		return
+	}
+	offsets = make([]uint64, len(vs))
+	for i, v := range vs {
+		offsets[i] = littleEndianOffset(v)
+	}
+	// the records in delta are after the records in shards.
+	if shards.delta != nil {
+		deltaOffsets, e := shards.delta.Gets(hash64)
+		if e != nil && e != os.ErrNotExist {
+			return nil, e
+		}
+		offsets = append(offsets, deltaOffsets...)
+	}
+	if len(offsets) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return offsets, nil
+}
+
+// Close closes the hash tables of all shards and the delta.
+// All of them are closed even if one fails, the first error is returned.
+func (shards *Shards) Close() (err error) {
+	if shards.delta != nil {
+		err = shards.delta.Close()
+	}
+	for _, table := range shards.tables {
+		if table == nil {
+			continue
+		}
+		if e := table.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
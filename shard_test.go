@@ -3,6 +3,7 @@ package zyxindex
 import (
 	"bytes"
 	"encoding/binary"
+	"os"
 	"testing"
 )
 
@@ -23,11 +24,11 @@ func TestLittleEndianKey(t *testing.T) {
 
 func TestLittleEndianOffset(t *testing.T) {
 	offset := uint64(28572051027328338)
-	b := make([]byte, vLen)
+	b := make([]byte, minVLen)
 	littleEndianPutOffset(b, offset)
 	b2 := make([]byte, 8)
 	binary.LittleEndian.PutUint64(b2, offset)
-	if !bytes.Equal(b, b2[:vLen]) {
+	if !bytes.Equal(b, b2[:minVLen]) {
 		t.Errorf("%v should equal expected(%v)", b, b2[:kLen])
 	}
 	expected := littleEndianOffset(b)
@@ -36,9 +37,44 @@ func TestLittleEndianOffset(t *testing.T) {
 	}
 }
 
+func TestLittleEndianOffset_Width(t *testing.T) {
+	offset := uint64(0x0102030405060708)
+	for width := minVLen; width <= maxVLen; width++ {
+		b := make([]byte, width)
+		littleEndianPutOffset(b, offset)
+		expected := offset << (64 - 8*uint(width)) >> (64 - 8*uint(width))
+		if v := littleEndianOffset(b); v != expected {
+			t.Errorf("%v: %x should equal expected(%x)", width, v, expected)
+		}
+	}
+}
+
+func TestOffsetWidth(t *testing.T) {
+	for _, c := range []struct {
+		size  int64
+		width int
+	}{
+		{0, 5},
+		{1 << 40, 5},
+		{1<<40 + 1, 6},
+		{1 << 48, 6},
+		{1<<56 + 1, 8},
+	} {
+		if width := offsetWidth(c.size); width != c.width {
+			t.Errorf("%v: %v should equal expected(%v)", c.size, width, c.width)
+		}
+		if c.size > 0 && offsetOverflow(uint64(c.size-1), c.width) {
+			t.Errorf("%v: should not overflow %v bytes", c.size, c.width)
+		}
+	}
+	if !offsetOverflow(1<<40, 5) {
+		t.Error("1<<40 should overflow 5 bytes")
+	}
+}
+
 func TestCalcShard(t *testing.T) {
 	hash64 := uint64(28572051027328338)
-	shardId, key := calcShard(hash64)
+	shardId, key := calcShard(hash64, 8)
 	b := make([]byte, kLen)
 	littleEndianPutKey(b, hash64)
 	if !bytes.Equal(b, key) {
@@ -57,16 +93,38 @@ type MapHashTable struct {
 
 func (m *MapHashTable) Get(k []byte) (v []byte, err error) {
 	key := littleEndianKey(k)
-	v = m.Map[key]
+	v, ok := m.Map[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
 	return
 }
 
+func (m *MapHashTable) Gets(k []byte) (vs [][]byte, err error) {
+	v, err := m.Get(k)
+	if v != nil {
+		vs = [][]byte{v}
+	}
+	return
+}
+
+func (m *MapHashTable) Range(fn func(k, v []byte) error) error {
+	k := make([]byte, kLen)
+	for key, v := range m.Map {
+		littleEndianPutKey(k, key)
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (m *MapHashTable) Close() error {
 	return nil
 }
 
 func TestShards_Get(t *testing.T) {
-	var shards Shards
+	shards := newShards(8)
 	b1 := make([]byte, 7)
 	littleEndianPutOffset(b1, 0)
 	b2 := make([]byte, 7)
@@ -74,13 +132,13 @@ func TestShards_Get(t *testing.T) {
 	b3 := make([]byte, 7)
 	littleEndianPutOffset(b3, 200)
 
-	shards[0] = &MapHashTable{
+	shards.tables[0] = &MapHashTable{
 		Map: map[uint64][]byte{
 			0:   b1,
 			256: b2,
 		},
 	}
-	shards[127] = &MapHashTable{
+	shards.tables[127] = &MapHashTable{
 		Map: map[uint64][]byte{
 			101: b3,
 		},
@@ -94,4 +152,60 @@ func TestShards_Get(t *testing.T) {
 	if v, _ := shards.Get(127<<56 | 101); v != 200 {
 		t.Errorf("%v should equal expected(%v)", v, 200)
 	}
+
+	// the offsets in shards are before the offsets in delta.
+	delta := newShards(0)
+	b4 := make([]byte, 7)
+	littleEndianPutOffset(b4, 300)
+	b5 := make([]byte, 7)
+	littleEndianPutOffset(b5, 400)
+	delta.tables[0] = &MapHashTable{
+		Map: map[uint64][]byte{
+			256: b4,
+			1:   b5,
+		},
+	}
+	shards.delta = &delta
+	if v, _ := shards.Get(256); v != 100 {
+		t.Errorf("%v should equal expected(%v)", v, 100)
+	}
+	if v, _ := shards.Get(1); v != 400 {
+		t.Errorf("%v should equal expected(%v)", v, 400)
+	}
+	if _, err := shards.Get(2); err != os.ErrNotExist {
+		t.Errorf("%v should equal expected(%v)", err, os.ErrNotExist)
+	}
+}
+
+func TestCalcShard_ShardBits(t *testing.T) {
+	hash64 := uint64(0xfedcba9876543210)
+	for _, c := range []struct {
+		shardBits uint
+		shardId   int
+	}{
+		{0, 0},
+		{1, 1},
+		{8, 0xfe},
+		{12, 0xfed},
+		{16, 0xfedc},
+	} {
+		shardId, _ := calcShard(hash64, c.shardBits)
+		if shardId != c.shardId {
+			t.Errorf("%v: %x should equal expected(%x)", c.shardBits, shardId, c.shardId)
+		}
+	}
+}
+
+func TestShardBitsOf(t *testing.T) {
+	if bits, ok := shardBitsOf(4096); !ok || bits != 12 {
+		t.Errorf("%v should equal expected(%v)", bits, 12)
+	}
+	if bits, ok := shardBitsOf(1); !ok || bits != 0 {
+		t.Errorf("%v should equal expected(%v)", bits, 0)
+	}
+	for _, n := range []int{0, 3, 1 << 17} {
+		if _, ok := shardBitsOf(n); ok {
+			t.Errorf("%v should be invalid", n)
+		}
+	}
 }
@@ -0,0 +1,69 @@
+package zyxindex
+
+// Stats is the statistics of the indexes of a DB.
+type Stats struct {
+	Generation  int      `json:"generation"`
+	Shards      int      `json:"shards"`
+	OffsetWidth int      `json:"offset_width"`
+	Hash        HashFunc `json:"hash"`
+
+	// DataSize is the indexed bytes of the data file, the records before
+	// BaseSize are indexed by the base shards, the rest by the delta.
+	DataSize     int64 `json:"data_size"`
+	BaseSize     int64 `json:"base_size"`
+	DeltaEntries int   `json:"delta_entries"`
+
+	// Entries and Slots are of all hash tables, the delta included.
+	Entries uint64 `json:"entries"`
+	Slots   uint64 `json:"slots"`
+	// MinShardEntries and MaxShardEntries are of the base shards.
+	MinShardEntries uint64 `json:"min_shard_entries"`
+	MaxShardEntries uint64 `json:"max_shard_entries"`
+	// MaxProbeLength is the most slots probed to find a key in the base
+	// shards, 0 if unknown.
+	MaxProbeLength int `json:"max_probe_length"`
+}
+
+// tableCounter is implemented by the hash tables which count their slots.
+type tableCounter interface {
+	SlotCount() uint64
+	EntryCount() uint64
+}
+
+// Stats returns the statistics of the indexes.
+func (db *DB) Stats() (stats Stats) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	m := db.manifest
+	stats = Stats{
+		Generation:     m.Generation,
+		Shards:         m.ShardNum,
+		OffsetWidth:    m.OffsetWidth,
+		Hash:           m.hashFunc(),
+		DataSize:       m.DataSize,
+		BaseSize:       m.BaseSize,
+		DeltaEntries:   m.DeltaEntries,
+		MaxProbeLength: m.MaxProbeLength,
+	}
+	for shards, delta := &db.shards, false; shards != nil; shards, delta = shards.delta, true {
+		for i, table := range shards.tables {
+			c, ok := table.(tableCounter)
+			if !ok {
+				continue
+			}
+			entries := c.EntryCount()
+			stats.Entries += entries
+			stats.Slots += c.SlotCount()
+			if delta {
+				continue
+			}
+			if i == 0 || entries < stats.MinShardEntries {
+				stats.MinShardEntries = entries
+			}
+			if entries > stats.MaxShardEntries {
+				stats.MaxShardEntries = entries
+			}
+		}
+	}
+	return
+}
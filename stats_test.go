@@ -0,0 +1,43 @@
+package zyxindex
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestDB_Stats(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	for i := 0; i < 100; i++ {
+		writeRecord(file, strconv.Itoa(i), strconv.Itoa(i*i))
+	}
+	db, err := OpenWithOptions(dataPath, &Options{ShardBits: 2, Hash: HashSipHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stats := db.Stats()
+	if stats.Shards != 4 || stats.Hash != HashSipHash || stats.Entries != 100 || stats.DeltaEntries != 0 ||
+		stats.DataSize != stats.BaseSize || stats.MaxProbeLength == 0 {
+		t.Errorf("%+v should have 4 shards and 100 entries", stats)
+	}
+	if stats.MinShardEntries > 25 || stats.MaxShardEntries < 25 || stats.Slots < 3*stats.Entries {
+		t.Errorf("%+v should have 25 entries by shard on average", stats)
+	}
+
+	writeRecord(file, "abc", "def")
+	file.Close()
+	if err := db.Refresh(); err != nil {
+		t.Fatal("refresh failed", err)
+	}
+	stats = db.Stats()
+	if stats.Entries != 101 || stats.DeltaEntries != 1 || stats.DataSize <= stats.BaseSize {
+		t.Errorf("%+v should have 1 delta entry", stats)
+	}
+}
@@ -0,0 +1,65 @@
+package zyxindex
+
+import (
+	"fmt"
+	"io"
+)
+
+// ValueReader reads a value in the data file without loading it into
+// memory. It implements io.Reader, io.ReaderAt and io.Seeker, and Size
+// returns the size of the value.
+//
+// A ValueReader reads the data file of the DB, it fails after the DB is
+// closed. It is not safe for concurrent use except ReadAt.
+type ValueReader struct {
+	*io.SectionReader
+}
+
+// OpenValue returns a ValueReader of the value for the given key, the first
+// one in file order as Get.
+//
+// @return err, os.ErrNotExist if the key is not found.
+func (db *DB) OpenValue(key []byte) (vr *ValueReader, err error) {
+	l := lookupPool.Get().(*lookup)
+	defer lookupPool.Put(l)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	err = db.findRecords(key, l, func(offset int64, size uint64) bool {
+		vr = &ValueReader{io.NewSectionReader(db.file, offset, int64(size))}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// GetRange gets at most n bytes from off of the value for the given key,
+// the bytes after the end of the value are not returned.
+//
+// @return err, os.ErrNotExist if the key is not found, io.EOF if off is
+// beyond the end of the value, ErrInvalidRange if off or n is negative.
+func (db *DB) GetRange(key []byte, off, n int64) (value []byte, err error) {
+	if off < 0 || n < 0 {
+		return nil, fmt.Errorf("%w: offset %d, length %d", ErrInvalidRange, off, n)
+	}
+	vr, err := db.OpenValue(key)
+	if err != nil {
+		return
+	}
+	if off > vr.Size() {
+		return nil, io.EOF
+	}
+	if n > vr.Size()-off {
+		n = vr.Size() - off
+	}
+	value = make([]byte, n)
+	if n == 0 {
+		return
+	}
+	_, err = vr.ReadAt(value, off)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
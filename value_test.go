@@ -0,0 +1,68 @@
+package zyxindex
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDB_OpenValue(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	large := strings.Repeat("0123456789", 100000)
+	writeRecord(file, "large", large)
+	writeRecord(file, "empty", "")
+	writeRecord(file, "large", "second")
+	file.Close()
+	db, err := Open(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	vr, err := db.OpenValue([]byte("large"))
+	if err != nil {
+		t.Fatal("open value failed", err)
+	}
+	if vr.Size() != int64(len(large)) {
+		t.Errorf("%v should equal expected(%v)", vr.Size(), len(large))
+	}
+	b, err := io.ReadAll(vr)
+	if err != nil || string(b) != large {
+		t.Errorf("value should equal the large value, %v", err)
+	}
+	vr.Seek(-5, io.SeekEnd)
+	if b, _ := io.ReadAll(vr); string(b) != "56789" {
+		t.Errorf("%q should equal %q", b, "56789")
+	}
+	if _, err := db.OpenValue([]byte("missing")); err != os.ErrNotExist {
+		t.Errorf("missing: data shoule not exist, %v", err)
+	}
+
+	for _, c := range []struct {
+		key    string
+		off, n int64
+		value  string
+		err    error
+	}{
+		{"large", 10, 5, "01234", nil},
+		{"large", int64(len(large)) - 3, 10, "789", nil},
+		{"large", int64(len(large)), 1, "", nil},
+		{"large", int64(len(large)) + 1, 1, "", io.EOF},
+		{"large", -1, 1, "", ErrInvalidRange},
+		{"empty", 0, 10, "", nil},
+		{"missing", 0, 10, "", os.ErrNotExist},
+	} {
+		value, err := db.GetRange([]byte(c.key), c.off, c.n)
+		if !errors.Is(err, c.err) || string(value) != c.value {
+			t.Errorf("%v %v %v: %q, %v should equal expected(%q, %v)", c.key, c.off, c.n, value, err, c.value, c.err)
+		}
+	}
+}
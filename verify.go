@@ -0,0 +1,300 @@
+package zyxindex
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+/*
+	verify the indexes against the data file.
+
+	1. the checksums of all hash tables are verified.
+	2. every record indexed is scanned, and its key must resolve to its own
+	   offset, or it is missing.
+	3. every entry of the hash tables must point at the start of a record
+	   scanned in 2, and the record must end before the end of its shards, or
+	   it is dangling; the key of the record must hash to the shard and the key bits
+	   of the entry, or it is misplaced. The entries of the base shards point
+	   before Manifest.BaseSize, the entries of the delta after it.
+*/
+
+// DefaultMaxProblems is the default of VerifyOptions.MaxProblems.
+const DefaultMaxProblems = 100
+
+// VerifyOptions holds the optional parameters for DB.Verify.
+// A nil *VerifyOptions is valid and means all defaults.
+type VerifyOptions struct {
+	// SkipChecksums skips verifying the checksums of the hash tables.
+	//
+	// The default is false.
+	SkipChecksums bool
+
+	// MaxProblems defines the max problems kept in VerifyReport.Problems,
+	// all problems are counted.
+	//
+	// The default is DefaultMaxProblems.
+	MaxProblems int
+}
+
+func (o *VerifyOptions) GetSkipChecksums() bool {
+	if o == nil {
+		return false
+	}
+	return o.SkipChecksums
+}
+
+func (o *VerifyOptions) GetMaxProblems() int {
+	if o == nil || o.MaxProblems <= 0 {
+		return DefaultMaxProblems
+	}
+	return o.MaxProblems
+}
+
+// ProblemKind is the kind of a problem found by DB.Verify.
+type ProblemKind int
+
+const (
+	// ProblemChecksum is a hash table whose checksum mismatches.
+	ProblemChecksum ProblemKind = iota
+	// ProblemMissing is a record not resolved to its offset by the indexes.
+	ProblemMissing
+	// ProblemDangling is an entry not pointing at a record.
+	ProblemDangling
+	// ProblemMisplaced is an entry pointing at a record of another key.
+	ProblemMisplaced
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemChecksum:
+		return "checksum"
+	case ProblemMissing:
+		return "missing"
+	case ProblemDangling:
+		return "dangling"
+	case ProblemMisplaced:
+		return "misplaced"
+	}
+	return "unknown"
+}
+
+// VerifyProblem is a problem found by DB.Verify.
+type VerifyProblem struct {
+	Kind ProblemKind
+	// Shard is the shard of the hash table, Delta is true if it is the delta.
+	Shard int
+	Delta bool
+	// Offset is the offset of the record in the data file, or the offset the
+	// entry points at.
+	Offset int64
+	Reason string
+}
+
+func (p VerifyProblem) String() string {
+	table := fmt.Sprintf("shard %d", p.Shard)
+	if p.Delta {
+		table = "delta"
+	}
+	if p.Kind == ProblemChecksum {
+		return fmt.Sprintf("%v: %s: %s", p.Kind, table, p.Reason)
+	}
+	return fmt.Sprintf("%v: %s: offset %d: %s", p.Kind, table, p.Offset, p.Reason)
+}
+
+// VerifyReport is the result of DB.Verify.
+type VerifyReport struct {
+	// Records is the records indexed in the data file.
+	Records int64
+	// Entries is the entries in the hash tables.
+	Entries int64
+
+	// the problems of every kind.
+	Checksums int64
+	Missing   int64
+	Dangling  int64
+	Misplaced int64
+	// Problems is the first VerifyOptions.MaxProblems problems.
+	Problems []VerifyProblem
+
+	maxProblems int
+}
+
+// OK reports whether no problem is found.
+func (r *VerifyReport) OK() bool {
+	return r.Checksums+r.Missing+r.Dangling+r.Misplaced == 0
+}
+
+func (r *VerifyReport) String() string {
+	return fmt.Sprintf("%d records, %d entries: %d checksum mismatches, %d missing, %d dangling, %d misplaced",
+		r.Records, r.Entries, r.Checksums, r.Missing, r.Dangling, r.Misplaced)
+}
+
+func (r *VerifyReport) add(p VerifyProblem) {
+	switch p.Kind {
+	case ProblemChecksum:
+		r.Checksums++
+	case ProblemMissing:
+		r.Missing++
+	case ProblemDangling:
+		r.Dangling++
+	case ProblemMisplaced:
+		r.Misplaced++
+	}
+	if len(r.Problems) < r.maxProblems {
+		r.Problems = append(r.Problems, p)
+	}
+}
+
+// Verify checks the indexes against the records of the data file they index.
+// The problems found are in the report, err is returned only if the check
+// can not go on: ctx is done, a record is corrupt, or reading fails.
+func (db *DB) Verify(ctx context.Context, o *VerifyOptions) (report *VerifyReport, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	report = &VerifyReport{maxProblems: o.GetMaxProblems()}
+	if !o.GetSkipChecksums() {
+		db.verifyChecksums(report)
+	}
+	starts, err := db.verifyRecords(ctx, report)
+	if err != nil {
+		return
+	}
+	err = db.verifyEntries(ctx, report, starts)
+	return
+}
+
+// verifier is implemented by the hash tables which have checksums.
+type verifier interface {
+	Verify() error
+}
+
+func (db *DB) verifyChecksums(report *VerifyReport) {
+	for shards, delta := &db.shards, false; shards != nil; shards, delta = shards.delta, true {
+		for i, table := range shards.tables {
+			v, ok := table.(verifier)
+			if !ok {
+				continue
+			}
+			if e := v.Verify(); e != nil {
+				report.add(VerifyProblem{Kind: ProblemChecksum, Shard: i, Delta: delta, Reason: e.Error()})
+			}
+		}
+	}
+}
+
+// verifyRecords resolves every record indexed by the shards.
+// @return starts, the offsets of the records in ascending order.
+func (db *DB) verifyRecords(ctx context.Context, report *VerifyReport) (starts []int64, err error) {
+	d := newRecordDecoder(db.file, 0, db.manifest.DataSize, false, true, db.o)
+	for {
+		offset, key, _, e := d.next()
+		if e == io.EOF {
+			return
+		}
+		if e != nil {
+			return nil, e
+		}
+		starts = append(starts, offset)
+		report.Records++
+		if report.Records%scanCheckRecords == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		hash64 := db.hasher.Sum64(key)
+		offsets, e := db.shards.Gets(hash64)
+		if e != nil && e != os.ErrNotExist {
+			return nil, e
+		}
+		found := false
+		for _, o := range offsets {
+			if int64(o) == offset {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report.add(VerifyProblem{
+				Kind:   ProblemMissing,
+				Shard:  shardIdOf(hash64, db.shards.shardBits),
+				Delta:  offset >= db.manifest.BaseSize,
+				Offset: offset,
+				Reason: fmt.Sprintf("key %q is not resolved to its record", key),
+			})
+		}
+	}
+}
+
+// verifyEntries checks the record every entry of the hash tables points at.
+// @param starts, the offsets of the records scanned by verifyRecords.
+func (db *DB) verifyEntries(ctx context.Context, report *VerifyReport, starts []int64) (err error) {
+	var head [sizeOfuint64]byte
+	var key []byte
+	for shards, delta := &db.shards, false; shards != nil; shards, delta = shards.delta, true {
+		// the offsets of the base shards are before BaseSize, of the delta after.
+		from, to := int64(0), db.manifest.BaseSize
+		if delta {
+			from, to = db.manifest.BaseSize, db.manifest.DataSize
+		}
+		for i, table := range shards.tables {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			err = table.Range(func(k, v []byte) error {
+				report.Entries++
+				offset := int64(littleEndianOffset(v))
+				problem := VerifyProblem{Kind: ProblemDangling, Shard: i, Delta: delta, Offset: offset}
+				if offset < from || offset+recordHeadLen > to {
+					problem.Reason = fmt.Sprintf("out of the indexed records [%d, %d)", from, to)
+					report.add(problem)
+					return nil
+				}
+				if j := sort.Search(len(starts), func(j int) bool { return starts[j] >= offset }); j == len(starts) || starts[j] != offset {
+					problem.Reason = "not at the start of a record"
+					report.add(problem)
+					return nil
+				}
+				_, e := db.file.ReadAt(head[:], offset)
+				if e != nil {
+					return e
+				}
+				keySize := binary.LittleEndian.Uint64(head[:])
+				if keySize > db.o.GetMaxKeySize() || keySize > uint64(to-offset-recordHeadLen) {
+					problem.Reason = fmt.Sprintf("invalid key size %d", keySize)
+					report.add(problem)
+					return nil
+				}
+				// the key and the value size.
+				n := keySize + sizeOfuint64
+				if uint64(cap(key)) < n {
+					key = make([]byte, n)
+				}
+				key = key[:n]
+				_, e = db.file.ReadAt(key, offset+sizeOfuint64)
+				if e != nil {
+					return e
+				}
+				valueSize := binary.LittleEndian.Uint64(key[keySize:])
+				key = key[:keySize]
+				if valueSize > uint64(to-offset-recordHeadLen)-keySize {
+					problem.Reason = fmt.Sprintf("value size %d exceeds the indexed records [%d, %d)", valueSize, from, to)
+					report.add(problem)
+					return nil
+				}
+				hash64 := db.hasher.Sum64(key)
+				if shardIdOf(hash64, shards.shardBits) != i || littleEndianKey(k) != hash64&(1<<(kLen*8)-1) {
+					problem.Kind = ProblemMisplaced
+					problem.Reason = fmt.Sprintf("key %q is hashed to another slot", key)
+					report.add(problem)
+				}
+				return nil
+			})
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package zyxindex
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestDB_Verify(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	for i := 0; i < 100; i++ {
+		writeRecord(file, strconv.Itoa(i), strconv.Itoa(i*i))
+	}
+	file.Close()
+	db, err := OpenWithOptions(dataPath, &Options{ShardBits: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	report, err := db.Verify(context.Background(), nil)
+	if err != nil || !report.OK() || report.Records != 100 || report.Entries != 100 {
+		t.Fatalf("%v should be ok, %v", report, err)
+	}
+
+	// point the first entry at the next record, and the second one out of
+	// the data file.
+	table, err := os.OpenFile(HashTablePath(GenerationPath(testIndexDir, db.manifest.BaseGeneration), 0), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	slotLen := int64(kLen + db.manifest.OffsetWidth)
+	slot := make([]byte, slotLen)
+	empty := notExistSlot(db.manifest.OffsetWidth)
+	var entries []int64
+	for i := int64(0); len(entries) < 2; i++ {
+		table.ReadAt(slot, headerLen+i*slotLen)
+		if !bytes.Equal(slot, empty) {
+			entries = append(entries, headerLen+i*slotLen)
+		}
+	}
+	table.ReadAt(slot, entries[0])
+	d := newRecordDecoder(db.file, int64(littleEndianOffset(slot[kLen:])), db.manifest.DataSize, false, true, nil)
+	d.next()
+	next := uint64(d.offset)
+	if next == uint64(db.manifest.DataSize) {
+		next = 0
+	}
+	littleEndianPutOffset(slot[kLen:], next)
+	table.WriteAt(slot, entries[0])
+	table.ReadAt(slot, entries[1])
+	littleEndianPutOffset(slot[kLen:], uint64(db.manifest.DataSize)+1)
+	table.WriteAt(slot, entries[1])
+
+	report, err = db.Verify(context.Background(), &VerifyOptions{MaxProblems: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || report.Checksums != 1 || report.Missing != 2 || report.Dangling != 1 || report.Misplaced != 1 {
+		t.Errorf("%v should have 1 checksum mismatch, 2 missing, 1 dangling and 1 misplaced", report)
+	}
+	if len(report.Problems) != 3 || report.Problems[0].Kind != ProblemChecksum {
+		t.Errorf("%v should have 3 problems", report.Problems)
+	}
+	report, err = db.Verify(context.Background(), &VerifyOptions{SkipChecksums: true})
+	if err != nil || report.Checksums != 0 || len(report.Problems) != 4 {
+		t.Errorf("%v should have 4 problems, %v", report, err)
+	}
+	for _, p := range report.Problems {
+		if p.Kind == ProblemMisplaced && p.Offset != int64(next) {
+			t.Errorf("%v should be at offset %v", p, next)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.Verify(ctx, nil); err != context.Canceled {
+		t.Errorf("%v should equal expected(%v)", err, context.Canceled)
+	}
+}
+
+func TestDB_VerifyRecordStart(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	// the value of outer at 21 looks like a record of inner, the record of
+	// inner is at 43.
+	inner := []byte{5, 0, 0, 0, 0, 0, 0, 0, 'i', 'n', 'n', 'e', 'r', 1, 0, 0, 0, 0, 0, 0, 0, 'v'}
+	writeRecord(file, "outer", string(inner))
+	writeRecord(file, "inner", "w")
+	file.Close()
+	db, err := OpenWithOptions(dataPath, &Options{ShardBits: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// point the entry of inner into the value of outer.
+	table, err := os.OpenFile(HashTablePath(GenerationPath(testIndexDir, db.manifest.BaseGeneration), 0), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	slotLen := int64(kLen + db.manifest.OffsetWidth)
+	slot := make([]byte, slotLen)
+	for i := int64(0); ; i++ {
+		if _, err := table.ReadAt(slot, headerLen+i*slotLen); err != nil {
+			t.Fatal("the entry of inner is not found", err)
+		}
+		if littleEndianOffset(slot[kLen:]) == 43 && !bytes.Equal(slot, notExistSlot(db.manifest.OffsetWidth)) {
+			littleEndianPutOffset(slot[kLen:], 21)
+			table.WriteAt(slot, headerLen+i*slotLen)
+			break
+		}
+	}
+
+	report, err := db.Verify(context.Background(), &VerifyOptions{SkipChecksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Missing != 1 || report.Dangling != 1 || report.Misplaced != 0 {
+		t.Errorf("%v should have 1 missing and 1 dangling", report)
+	}
+	for _, p := range report.Problems {
+		if p.Kind == ProblemDangling && p.Offset != 21 {
+			t.Errorf("%v should be at offset %v", p, 21)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Package zyxhttp serves a zyxindex DB over HTTP, read only.
+//
+// Routes:
+//
+//	GET  /v1/keys/{key}  the value of key, with Content-Length and Range support
+//	HEAD /v1/keys/{key}  200 if key exists, 404 otherwise
+//	POST /v1/multiget    the values of a batch of keys
+//	GET  /healthz        200 "ok"
+//	GET  /stats          DB.Stats as json
+//
+// The key in the path is raw bytes, percent encoded if needed, or URL safe
+// base64 with the query ?encoding=base64. The keys which are not clean paths,
+// such as the keys with "//" or "..", are redirected by http.ServeMux, use
+// base64 for them.
+package zyxhttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"tcmichael/zyxindex"
+)
+
+// DefaultMaxBatch is the default of Options.MaxBatch.
+const DefaultMaxBatch = 1000
+
+// DefaultMaxBodySize is the default of Options.MaxBodySize.
+const DefaultMaxBodySize = 1 << 20
+
+// DefaultMaxResponseBytes is the default of Options.MaxResponseBytes.
+const DefaultMaxResponseBytes = 64 << 20
+
+// keysPath is the prefix of the key routes.
+const keysPath = "/v1/keys/"
+
+// Options holds the optional parameters for the Handler.
+// A nil *Options is valid and means all defaults.
+type Options struct {
+	// MaxBatch defines the max keys of a multiget request.
+	//
+	// The default is DefaultMaxBatch.
+	MaxBatch int
+
+	// MaxBodySize defines the max bytes of a multiget request body.
+	//
+	// The default is DefaultMaxBodySize.
+	MaxBodySize int64
+
+	// MaxResponseBytes defines the max bytes of the values of a multiget
+	// response, which are read into memory. The sizes are checked before
+	// the values are read, the larger requests get 413.
+	//
+	// The default is DefaultMaxResponseBytes.
+	MaxResponseBytes int64
+}
+
+func (o *Options) GetMaxBatch() int {
+	if o == nil || o.MaxBatch <= 0 {
+		return DefaultMaxBatch
+	}
+	return o.MaxBatch
+}
+
+func (o *Options) GetMaxBodySize() int64 {
+	if o == nil || o.MaxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return o.MaxBodySize
+}
+
+func (o *Options) GetMaxResponseBytes() int64 {
+	if o == nil || o.MaxResponseBytes <= 0 {
+		return DefaultMaxResponseBytes
+	}
+	return o.MaxResponseBytes
+}
+
+// Handler serves the DB over HTTP. The DB must stay open while the
+// Handler serves.
+type Handler struct {
+	db  *zyxindex.DB
+	o   *Options
+	mux *http.ServeMux
+}
+
+// NewHandler returns a Handler serving db.
+func NewHandler(db *zyxindex.DB, o *Options) *Handler {
+	h := &Handler{db: db, o: o, mux: http.NewServeMux()}
+	h.mux.HandleFunc(keysPath, h.serveKey)
+	h.mux.HandleFunc("/v1/multiget", h.serveMultiGet)
+	h.mux.HandleFunc("/healthz", h.serveHealthz)
+	h.mux.HandleFunc("/stats", h.serveStats)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// allow replies 405 if the method of r is not one of methods.
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+// decodeKey decodes a key in the encoding, "" for raw and "base64" for
+// URL safe base64, padded or not.
+func decodeKey(s, encoding string) (key []byte, err error) {
+	switch encoding {
+	case "":
+		return []byte(s), nil
+	case "base64":
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+func (h *Handler) serveKey(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	key, err := decodeKey(strings.TrimPrefix(r.URL.Path, keysPath), r.URL.Query().Get("encoding"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vr, err := h.db.OpenValue(key)
+	if err == os.ErrNotExist {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", time.Time{}, vr)
+}
+
+// MultiGetRequest is the body of POST /v1/multiget.
+type MultiGetRequest struct {
+	Keys []string `json:"keys"`
+	// Encoding is the encoding of Keys, "" for raw and "base64" for URL safe
+	// base64.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// MultiGetResult is the result of a key of MultiGetRequest.Keys, Value is
+// base64 encoded in json.
+type MultiGetResult struct {
+	Found bool   `json:"found"`
+	Value []byte `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// MultiGetResponse is the response of POST /v1/multiget, Results[i] is the
+// result of MultiGetRequest.Keys[i].
+type MultiGetResponse struct {
+	Results []MultiGetResult `json:"results"`
+}
+
+func (h *Handler) serveMultiGet(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	max := h.o.GetMaxBodySize()
+	body, err := io.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > max {
+		http.Error(w, fmt.Sprintf("body exceeds %d bytes", max), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var req MultiGetRequest
+	if err = json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Keys) > h.o.GetMaxBatch() {
+		http.Error(w, fmt.Sprintf("%d keys exceed %d", len(req.Keys), h.o.GetMaxBatch()), http.StatusRequestEntityTooLarge)
+		return
+	}
+	keys := make([][]byte, len(req.Keys))
+	for i, s := range req.Keys {
+		keys[i], err = decodeKey(s, req.Encoding)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("key %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+	// the sizes of the values are checked before they are read into memory.
+	maxBytes := h.o.GetMaxResponseBytes()
+	values, errs, err := h.db.MultiGetLimit(keys, maxBytes)
+	if errors.Is(err, zyxindex.ErrValueTooLarge) {
+		http.Error(w, fmt.Sprintf("values exceed %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := MultiGetResponse{Results: make([]MultiGetResult, len(keys))}
+	for i, e := range errs {
+		switch e {
+		case nil:
+			resp.Results[i] = MultiGetResult{Found: true, Value: values[i]}
+		case os.ErrNotExist:
+		default:
+			resp.Results[i].Error = e.Error()
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func (h *Handler) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+func (h *Handler) serveStats(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, h.db.Stats())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,150 @@
+package zyxhttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tcmichael/zyxindex"
+)
+
+const testDir = "test"
+
+func writeRecord(file *os.File, k, v string) {
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(k)))
+	file.Write(size[:])
+	file.WriteString(k)
+	binary.LittleEndian.PutUint64(size[:], uint64(len(v)))
+	file.Write(size[:])
+	file.WriteString(v)
+}
+
+func TestHandler(t *testing.T) {
+	defer os.RemoveAll(testDir)
+	os.Mkdir(testDir, 0755)
+	dataPath := testDir + "/data"
+	file, err := os.Create(dataPath)
+	if err != nil {
+		t.Fatal("create file failed", err)
+	}
+	for i := 0; i < 100; i++ {
+		writeRecord(file, strconv.Itoa(i), strconv.Itoa(i*i))
+	}
+	writeRecord(file, "a/b c", "0123456789")
+	writeRecord(file, "\x00\xff", "binary")
+	file.Close()
+	db, err := zyxindex.OpenWithOptions(dataPath, &zyxindex.Options{ShardBits: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	srv := httptest.NewServer(NewHandler(db, &Options{MaxBatch: 3}))
+	defer srv.Close()
+
+	do := func(method, path string, header http.Header, body string) (resp *http.Response, b string) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	binaryKey := "/v1/keys/" + base64.RawURLEncoding.EncodeToString([]byte("\x00\xff")) + "?encoding=base64"
+	tests := []struct {
+		method string
+		path   string
+		header http.Header
+		status int
+		body   string
+	}{
+		{"GET", "/v1/keys/7", nil, http.StatusOK, "49"},
+		{"GET", "/v1/keys/a%2Fb%20c", nil, http.StatusOK, "0123456789"},
+		{"GET", "/v1/keys/a%2Fb%20c", http.Header{"Range": {"bytes=2-4"}}, http.StatusPartialContent, "234"},
+		{"GET", "/v1/keys/a%2Fb%20c", http.Header{"Range": {"bytes=20-"}}, http.StatusRequestedRangeNotSatisfiable, ""},
+		{"GET", binaryKey, nil, http.StatusOK, "binary"},
+		{"GET", "/v1/keys/AP8=?encoding=base64", nil, http.StatusOK, "binary"},
+		{"GET", "/v1/keys/!!?encoding=base64", nil, http.StatusBadRequest, ""},
+		{"GET", "/v1/keys/7?encoding=hex", nil, http.StatusBadRequest, ""},
+		{"GET", "/v1/keys/100", nil, http.StatusNotFound, ""},
+		{"HEAD", "/v1/keys/7", nil, http.StatusOK, ""},
+		{"HEAD", "/v1/keys/100", nil, http.StatusNotFound, ""},
+		{"PUT", "/v1/keys/7", nil, http.StatusMethodNotAllowed, ""},
+		{"GET", "/v1/multiget", nil, http.StatusMethodNotAllowed, ""},
+		{"GET", "/healthz", nil, http.StatusOK, "ok\n"},
+	}
+	for _, tt := range tests {
+		resp, body := do(tt.method, tt.path, tt.header, "")
+		if resp.StatusCode != tt.status || (tt.body != "" && body != tt.body) {
+			t.Errorf("%s %s: %v %q should equal expected(%v %q)", tt.method, tt.path, resp.StatusCode, body, tt.status, tt.body)
+		}
+	}
+	resp, _ := do("HEAD", "/v1/keys/a%2Fb%20c", nil, "")
+	if resp.ContentLength != 10 || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("%v should equal expected(10)", resp.ContentLength)
+	}
+
+	resp, body := do("POST", "/v1/multiget", nil, `{"keys": ["3", "100", "a/b c"]}`)
+	var mr MultiGetResponse
+	if err := json.Unmarshal([]byte(body), &mr); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%v %s, %v", resp.StatusCode, body, err)
+	}
+	expected := []MultiGetResult{{Found: true, Value: []byte("9")}, {}, {Found: true, Value: []byte("0123456789")}}
+	for i, r := range mr.Results {
+		if r.Found != expected[i].Found || !bytes.Equal(r.Value, expected[i].Value) || r.Error != "" {
+			t.Errorf("%+v should equal expected(%+v)", r, expected[i])
+		}
+	}
+	resp, body = do("POST", "/v1/multiget", nil, `{"keys": ["AP8"], "encoding": "base64"}`)
+	if !strings.Contains(body, base64.StdEncoding.EncodeToString([]byte("binary"))) {
+		t.Errorf("%v %s should have the value", resp.StatusCode, body)
+	}
+	for body, status := range map[string]int{
+		`{"keys": ["1", "2", "3", "4"]}`:                   http.StatusRequestEntityTooLarge,
+		`{"keys": ["!!"], "encoding": "base64"}`:           http.StatusBadRequest,
+		`{"keys": `:                                        http.StatusBadRequest,
+		`{"keys": ["` + strings.Repeat("k", 2<<20) + `"]}`: http.StatusRequestEntityTooLarge,
+	} {
+		if resp, _ := do("POST", "/v1/multiget", nil, body); resp.StatusCode != status {
+			t.Errorf("%.40s: %v should equal expected(%v)", body, resp.StatusCode, status)
+		}
+	}
+
+	// the values of 10 bytes and 2 bytes exceed the max response bytes.
+	limited := httptest.NewServer(NewHandler(db, &Options{MaxResponseBytes: 11}))
+	defer limited.Close()
+	for body, status := range map[string]int{
+		`{"keys": ["a/b c", "100"]}`:  http.StatusOK,
+		`{"keys": ["a/b c", "10"]}`:   http.StatusRequestEntityTooLarge,
+		`{"keys": ["7", "8", "100"]}`: http.StatusOK,
+	} {
+		resp, err := http.Post(limited.URL+"/v1/multiget", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: %v should equal expected(%v)", body, resp.StatusCode, status)
+		}
+	}
+
+	resp, body = do("GET", "/stats", nil, "")
+	var stats zyxindex.Stats
+	if err := json.Unmarshal([]byte(body), &stats); err != nil || stats.Entries != 102 || stats.Shards != 4 {
+		t.Errorf("%v %s should have 102 entries, %v", resp.StatusCode, body, err)
+	}
+}